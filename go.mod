@@ -3,8 +3,12 @@ module github.com/Ajinx1/go-message-pattern-server
 go 1.23.0
 
 require (
-	github.com/jeanphorn/log4go v0.0.0-20231225120528-d93eb9001e51
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	golang.org/x/time v0.12.0
 )
 
-require github.com/toolkits/file v0.0.0-20160325033739-a5b3c5147e07 // indirect
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
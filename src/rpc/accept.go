@@ -1,64 +1,97 @@
 package rpc
 
 import (
-	"context"
-	"fmt"
+	"crypto/tls"
 	"net"
 	"time"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
 )
 
-// acceptConnections handles incoming connections with rate limiting and max connection checks
-func (s *Server) acceptConnections() {
+// deadlineListener is implemented by listeners that support accept deadlines (TCP, Unix)
+type deadlineListener interface {
+	SetDeadline(t time.Time) error
+}
+
+// acceptConnections handles incoming connections with max connection checks; rate limits apply per request.
+// It returns nil once the server shuts down, or the error that made the listener unusable.
+func (s *Server) acceptConnections(listener net.Listener) error {
 	defer s.wg.Done()
+	var tempDelay time.Duration
 	for {
-		if s.isShuttingDown {
-			return
+		if s.State() >= StateDraining {
+			return nil
 		}
 
 		// Check connection limit
 		s.connMu.RLock()
 		if len(s.activeConns) >= s.config.MaxConnections {
 			s.connMu.RUnlock()
-			utility.LogAndPrint(fmt.Sprintf("RPC: Max connections reached | Limit: %d", s.config.MaxConnections))
+			s.logger.Warn("Max connections reached", "limit", s.config.MaxConnections)
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 		s.connMu.RUnlock()
 
-		// Rate limiting
-		if err := s.limiter.Wait(context.Background()); err != nil {
-			utility.LogAndPrint(fmt.Sprintf("RPC: Rate limiter error | Error: %v", err))
-			continue
-		}
-
-		// Set accept timeout
-		if err := s.listener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-			utility.LogAndPrint(fmt.Sprintf("RPC: Failed to set accept deadline | Error: %v", err))
-			continue
+		// Set accept timeout; listeners without deadlines are unblocked by Close on shutdown
+		if dl, ok := listener.(deadlineListener); ok {
+			if err := dl.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				return s.acceptFailed(listener, err)
+			}
 		}
 
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			if s.isShuttingDown {
-				return
+			// back off on temporary errors such as running out of file descriptors, like net/http
+			if tempErr, ok := err.(interface{ Temporary() bool }); ok && tempErr.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				s.logger.Warn("Accept error, retrying", "address", listener.Addr().String(), "delay", tempDelay, "error", err)
+				time.Sleep(tempDelay)
+				continue
 			}
-			utility.LogAndPrint(fmt.Sprintf("RPC: Accept error | Error: %v", err))
-			continue
+			return s.acceptFailed(listener, err)
+		}
+		tempDelay = 0
+
+		// the handshake runs on the connection's own goroutine so a slow client cannot stall accepts
+		if s.tlsConfig != nil {
+			conn = tls.Server(conn, s.tlsConfig)
 		}
 
+		c := s.newConnection(conn)
 		s.connMu.Lock()
-		s.activeConns[conn] = struct{}{}
+		// Shutdown sets the state before it takes the connections it drains
+		if s.State() >= StateDraining {
+			s.connMu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.activeConns[c] = struct{}{}
 		s.metrics.mu.Lock()
 		s.metrics.ActiveConns++
 		s.metrics.mu.Unlock()
 		s.connMu.Unlock()
 
 		s.wg.Add(1)
-		go s.handleConnection(conn)
+		go s.handleConnection(c)
+	}
+}
+
+// acceptFailed drops a listener that can no longer accept; it is not an error during shutdown
+func (s *Server) acceptFailed(listener net.Listener, err error) error {
+	if s.State() >= StateDraining {
+		return nil
 	}
+	s.logger.Error("Accept error, closing listener", "address", listener.Addr().String(), "error", err)
+	s.removeListener(listener)
+	listener.Close()
+	return err
 }
@@ -0,0 +1,221 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a pattern's circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until OpenTimeout has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial calls through to probe whether the pattern recovered
+	BreakerHalfOpen
+)
+
+func (st BreakerState) String() string {
+	switch st {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(st))
+}
+
+func (st BreakerState) MarshalText() ([]byte, error) { return []byte(st.String()), nil }
+
+// CircuitBreakerConfig configures WithCircuitBreaker; zero fields take the defaults noted
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker, 5 by default
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before allowing trial calls, 30s by default
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is the number of concurrent trial calls while half-open, 1 by default
+	HalfOpenMaxCalls int
+	// SuccessThreshold is the number of successful trial calls that closes the breaker, 1 by default
+	SuccessThreshold int
+	// IsFailure decides which handler errors count against the breaker. By default every
+	// error does except client errors (*Error with a 4xx code) and cancellations.
+	IsFailure func(err error) bool
+}
+
+// BreakerStats is a snapshot of one pattern's circuit breaker, as reported by GetMetrics
+type BreakerStats struct {
+	State               BreakerState
+	ConsecutiveFailures int
+	Trips               uint64
+	Rejected            uint64
+}
+
+// WithCircuitBreaker guards one pattern with a circuit breaker. While it is open, calls fail
+// fast with a CodeUnavailable error instead of reaching the handler.
+func WithCircuitBreaker(config CircuitBreakerConfig) PatternOption {
+	return func(r *route) {
+		r.breaker = newCircuitBreaker(config)
+	}
+}
+
+// Unavailable is the error returned while a pattern's circuit breaker is open
+func Unavailable(message string) *Error { return NewError(CodeUnavailable, message) }
+
+type circuitBreaker struct {
+	config   CircuitBreakerConfig
+	pattern  string
+	logger   Logger
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	// successes and trials count completed and running calls in the half-open state
+	successes int
+	trials    int
+	openedAt  time.Time
+	trips     uint64
+	rejected  uint64
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenMaxCalls <= 0 {
+		config.HalfOpenMaxCalls = 1
+	}
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = defaultBreakerFailure
+	}
+	return &circuitBreaker{config: config, logger: defaultLogger()}
+}
+
+func defaultBreakerFailure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) && rpcErr.Code >= 400 && rpcErr.Code < 500 && rpcErr.Code != CodeTimeout {
+		return false
+	}
+	return true
+}
+
+// allow reports whether a call may proceed and, if not, how long until the next trial
+func (b *circuitBreaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		remaining := b.config.OpenTimeout - time.Since(b.openedAt)
+		if remaining > 0 {
+			b.rejected++
+			return remaining, false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.trials >= b.config.HalfOpenMaxCalls {
+			b.rejected++
+			return 0, false
+		}
+		b.trials++
+	}
+	return 0, true
+}
+
+// release frees the trial slot of an allowed call that ended without an outcome, e.g. because
+// the client cancelled it
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// record feeds the outcome of an allowed call back into the breaker
+func (b *circuitBreaker) record(err error) {
+	failed := err != nil && b.config.IsFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.open()
+		}
+	case BreakerHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		if failed {
+			b.failures++
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.SuccessThreshold {
+			b.failures = 0
+			b.setState(BreakerClosed)
+		}
+	case BreakerOpen:
+		// a call admitted before the breaker opened; its outcome changes nothing
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.trips++
+	b.setState(BreakerOpen)
+}
+
+// setState must be called with b.mu held
+func (b *circuitBreaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.logger.Warn("Circuit breaker state change",
+		"pattern", b.pattern, "from", b.state.String(), "to", state.String(), "failures", b.failures)
+	b.state = state
+	b.successes = 0
+	b.trials = 0
+}
+
+func (b *circuitBreaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		Rejected:            b.rejected,
+	}
+}
+
+// rejection is the error returned for a call the breaker did not let through
+func (b *circuitBreaker) rejection(retryAfter time.Duration) *Error {
+	rpcErr := Unavailable(fmt.Sprintf("Circuit breaker open for pattern %s", b.pattern))
+	if retryAfter > 0 {
+		rpcErr.WithDetail("retryAfter", (retryAfter + time.Millisecond - 1).Milliseconds())
+	}
+	return rpcErr
+}
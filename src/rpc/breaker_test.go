@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerCountsTimeouts(t *testing.T) {
+	s := newTestServer(t)
+	s.RegisterHandler("slow", func(json.RawMessage) (interface{}, error) {
+		time.Sleep(150 * time.Millisecond)
+		return "late", nil
+	}, WithTimeout(50*time.Millisecond), WithCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+	}))
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	codes := make([]int, 0, 4)
+	for i := 0; i < 4; i++ {
+		_, err := client.Send(context.Background(), "slow", nil)
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		codes = append(codes, rpcErr.Code)
+	}
+	want := []int{CodeTimeout, CodeTimeout, CodeUnavailable, CodeUnavailable}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("codes = %v, want %v", codes, want)
+		}
+	}
+
+	// the late handlers finishing must not reset the breaker
+	time.Sleep(200 * time.Millisecond)
+	if st := s.GetMetrics().CircuitBreakers["slow"]; st.State != BreakerOpen {
+		t.Fatalf("breaker = %+v, want open", st)
+	}
+}
+
+func TestBreakerReleasesHalfOpenTrialOnTimeout(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+	b.logger = NopLogger
+	b.record(errors.New("boom"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := b.allow(); !ok {
+		t.Fatal("trial call rejected")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("second concurrent trial allowed")
+	}
+	// the trial was abandoned: the next call may probe again
+	b.release()
+	if _, ok := b.allow(); !ok {
+		t.Fatal("trial slot not released")
+	}
+	b.record(nil)
+	if st := b.stats(); st.State != BreakerClosed {
+		t.Fatalf("state = %s, want closed", st.State)
+	}
+}
+
+func TestBreakerRejectionCarriesRetryAfter(t *testing.T) {
+	b := newCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second})
+	b.pattern, b.logger = "p", NopLogger
+	b.record(errors.New("boom"))
+	retryAfter, ok := b.allow()
+	if ok || retryAfter <= 0 {
+		t.Fatalf("allow = %s, %t", retryAfter, ok)
+	}
+	rpcErr := b.rejection(retryAfter)
+	if rpcErr.Code != CodeUnavailable || rpcErr.Details["retryAfter"] == nil {
+		t.Fatalf("rejection = %+v", rpcErr)
+	}
+}
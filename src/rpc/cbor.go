@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborCodec implements CBOR (RFC 8949) on top of fxamacker/cbor. Payloads are checked to be
+// well-formed, with every declared item present, before anything is allocated for them.
+type cborCodec struct{}
+
+var (
+	cborEnc cbor.EncMode
+	cborDec cbor.DecMode
+)
+
+// cborTranscoder adapts a func to cbor.Transcoder
+type cborTranscoder func(w io.Writer, r io.Reader) error
+
+func (f cborTranscoder) Transcode(w io.Writer, r io.Reader) error { return f(w, r) }
+
+func init() {
+	var err error
+	cborEnc, err = cbor.EncOptions{
+		Time:                    cbor.TimeRFC3339Nano,
+		JSONMarshalerTranscoder: cborTranscoder(jsonToCBOR),
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	cborDec, err = cbor.DecOptions{
+		MaxNestedLevels: maxDecodeDepth,
+		// the well-formedness check already bounds counts by the payload size
+		MaxArrayElements:          math.MaxInt32,
+		MaxMapPairs:               math.MaxInt32,
+		DefaultMapType:            reflect.TypeOf(map[string]interface{}(nil)),
+		JSONUnmarshalerTranscoder: cborTranscoder(cborToJSON),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (cborCodec) Name() string { return "CBOR" }
+
+// Match recognises a CBOR map header (major type 5); every envelope is a map
+func (cborCodec) Match(payload []byte) bool {
+	return len(payload) > 0 && payload[0]>>5 == 5
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) { return cborEnc.Marshal(v) }
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cborDec.Unmarshal(data, v) }
+
+func (cborCodec) decodeEnvelope(payload []byte) (*envelope, error) {
+	var raw struct {
+		ID       cbor.RawMessage `json:"id"`
+		Pattern  cbor.RawMessage `json:"pattern"`
+		Data     cbor.RawMessage `json:"data"`
+		Metadata cbor.RawMessage `json:"metadata"`
+		Cancel   bool            `json:"cancel"`
+	}
+	if err := cborDec.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	return &envelope{ID: raw.ID, Pattern: raw.Pattern, Data: raw.Data, Metadata: raw.Metadata, Cancel: raw.Cancel}, nil
+}
+
+// jsonToCBOR encodes json.Marshalers, such as json.RawMessage and *Error, from their JSON
+func jsonToCBOR(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	value, err := decodeJSONValue(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := cborEnc.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+// cborToJSON decodes into json.Unmarshalers, such as json.RawMessage, by way of JSON
+func cborToJSON(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err := cborDec.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
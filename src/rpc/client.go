@@ -0,0 +1,349 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClientClosed = errors.New("rpc: client closed")
+	// ErrStreamBufferFull ends a ClientStream whose values are not received fast enough
+	ErrStreamBufferFull = errors.New("rpc: stream buffer full")
+)
+
+type ClientConfig struct {
+	// Network is "tcp" (default) or "unix"
+	Network     string
+	Addr        string
+	DialTimeout time.Duration
+	// TLSConfig, when set, makes Dial connect over TLS; add Certificates for mutual TLS
+	TLSConfig *tls.Config
+	Codec     Codec
+	Framer    Framer
+	// MaxMessageBytes bounds the size of a response frame, 16 MiB by default
+	MaxMessageBytes int
+	// KeepAliveInterval is how often a ping is sent so the server's HeartbeatTimeout does
+	// not evict an otherwise idle client; 15s by default, a negative value disables it
+	KeepAliveInterval time.Duration
+	// StreamBufferSize is how many values a ClientStream buffers ahead of Recv, 1024 by
+	// default; a stream whose reader falls further behind fails with ErrStreamBufferFull
+	StreamBufferSize int
+}
+
+// Client talks to a Server (or a NestJS TCP microservice), correlating responses by
+// request ID. It uses NestJS "<len>#<json>" framing unless configured otherwise.
+type Client struct {
+	conn      net.Conn
+	codec     Codec
+	framer    Framer
+	maxBytes  int
+	streamBuf int
+	reader    *bufio.Reader
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	pending   map[string]chan clientResult
+	streams   map[string]*ClientStream
+	closed    chan struct{}
+	closeErr  error
+	closeOnce sync.Once
+}
+
+type clientResult struct {
+	data json.RawMessage
+	err  error
+}
+
+// clientResponse mirrors Response but keeps the payload raw for the caller to decode
+type clientResponse struct {
+	ID         string          `json:"id"`
+	Response   json.RawMessage `json:"response"`
+	IsDisposed bool            `json:"isDisposed"`
+	Status     string          `json:"status"`
+	Err        json.RawMessage `json:"err"`
+}
+
+func Dial(ctx context.Context, config *ClientConfig) (*Client, error) {
+	if config == nil || config.Addr == "" {
+		return nil, fmt.Errorf("rpc: client address is required")
+	}
+	timeout := config.DialTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{Timeout: timeout}
+	if config.TLSConfig != nil {
+		dialer = &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout}, Config: config.TLSConfig}
+	}
+	network := config.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, network, config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", config.Addr, err)
+	}
+	return NewClient(conn, config), nil
+}
+
+// NewClient wraps an established connection; the client owns it from then on.
+// Addr and DialTimeout of config are ignored; config may be nil.
+func NewClient(conn net.Conn, config *ClientConfig) *Client {
+	if config == nil {
+		config = &ClientConfig{}
+	}
+	c := &Client{
+		conn:      conn,
+		codec:     config.Codec,
+		framer:    config.Framer,
+		maxBytes:  config.MaxMessageBytes,
+		streamBuf: config.StreamBufferSize,
+		reader:    bufio.NewReader(conn),
+		pending:   make(map[string]chan clientResult),
+		streams:   make(map[string]*ClientStream),
+		closed:    make(chan struct{}),
+	}
+	if c.codec == nil {
+		c.codec = JSON
+	}
+	if c.framer == nil {
+		c.framer = NestFramer
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = 16 << 20
+	}
+	if c.streamBuf <= 0 {
+		c.streamBuf = 1024
+	}
+	go c.readLoop()
+	// below the server's default HeartbeatTimeout of 45s
+	keepAlive := config.KeepAliveInterval
+	if keepAlive == 0 {
+		keepAlive = 15 * time.Second
+	}
+	if keepAlive > 0 {
+		go c.keepAlive(keepAlive)
+	}
+	return c
+}
+
+// Send issues a request for pattern and waits for the first reply carrying the same ID
+func (c *Client) Send(ctx context.Context, pattern string, data interface{}) (json.RawMessage, error) {
+	id, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan clientResult, 1)
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return nil, c.closeErr
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer c.removePending(id)
+
+	payload, err := c.codec.Marshal(newEnvelope(ctx, id, pattern, data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if err := c.write(payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res.data, res.err
+	case <-c.closed:
+		return nil, c.closeErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Emit publishes a fire-and-forget event; no id is sent so the server never replies
+func (c *Client) Emit(ctx context.Context, pattern string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.isClosed() {
+		return c.closeErr
+	}
+
+	payload, err := c.codec.Marshal(newEnvelope(ctx, "", pattern, data))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return c.write(payload)
+}
+
+// Ping round-trips the built-in ping pattern
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Send(ctx, "ping", nil)
+	return err
+}
+
+func (c *Client) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// an id-less ping is answered without an id, which readLoop ignores
+			if err := c.Emit(context.Background(), "ping", nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	c.shutdown(ErrClientClosed)
+	return nil
+}
+
+func (c *Client) write(payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(c.framer.WriteFrame(payload)); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) readLoop() {
+	for {
+		msgBytes, err := c.framer.ReadFrame(c.reader, c.maxBytes)
+		if err != nil {
+			var frameErr *frameError
+			if errors.As(err, &frameErr) && frameErr.recoverable {
+				continue
+			}
+			c.shutdown(fmt.Errorf("rpc: connection lost: %w", err))
+			return
+		}
+
+		var resp clientResponse
+		if err := c.codec.Unmarshal(msgBytes, &resp); err != nil {
+			continue
+		}
+		// heartbeats are not tied to any request
+		if resp.ID == "" || resp.ID == "heartbeat" {
+			continue
+		}
+		c.deliver(resp)
+	}
+}
+
+func (c *Client) deliver(resp clientResponse) {
+	c.mu.Lock()
+	st, ok := c.streams[resp.ID]
+	c.mu.Unlock()
+	if ok {
+		if st.push(resp) {
+			c.removeStream(resp.ID)
+		}
+		return
+	}
+
+	var res clientResult
+	switch {
+	case len(resp.Err) > 0 && string(resp.Err) != "null":
+		res.err = decodeRemoteError(resp.Err)
+	case len(resp.Response) == 0 && !resp.IsDisposed && resp.Status == "":
+		// nothing to report yet
+		return
+	case len(resp.Response) == 0:
+		// a handler that returned nothing; the response field is omitted
+		res.data = json.RawMessage("null")
+	default:
+		res.data = resp.Response
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[resp.ID]
+	if ok {
+		delete(c.pending, resp.ID)
+	}
+	c.mu.Unlock()
+	if ok {
+		ch <- res
+	}
+}
+
+func (c *Client) removePending(id string) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closeErr = err
+		close(c.closed)
+		streams := c.streams
+		c.streams = make(map[string]*ClientStream)
+		c.mu.Unlock()
+		for _, st := range streams {
+			st.fail(err)
+		}
+		c.conn.Close()
+	})
+}
+
+// decodeRemoteError turns the "err" field of a response into an *Error
+func decodeRemoteError(raw json.RawMessage) error {
+	rpcErr := &Error{}
+	if err := json.Unmarshal(raw, rpcErr); err != nil {
+		return errors.New(string(raw))
+	}
+	return rpcErr
+}
+
+// newEnvelope builds the request frame; events have no id. The trace context of ctx, if any,
+// travels in the metadata field.
+func newEnvelope(ctx context.Context, id, pattern string, data interface{}) map[string]interface{} {
+	envelope := map[string]interface{}{
+		"pattern": Pattern{Cmd: pattern},
+		"data":    data,
+	}
+	if id != "" {
+		envelope["id"] = id
+	}
+	if metadata := injectSpanContext(ctx); metadata != nil {
+		envelope["metadata"] = metadata
+	}
+	return envelope
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate request id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
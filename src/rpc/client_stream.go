@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ClientStream receives the responses of a streaming request
+type ClientStream struct {
+	client    *Client
+	id        string
+	mu        sync.Mutex
+	queue     []json.RawMessage
+	err       error
+	notify    chan struct{}
+	closeOnce sync.Once
+}
+
+// Stream issues a request whose handler replies with a stream of values; read them with
+// Recv. Close the stream when done with it, which cancels the request on the server if it
+// is still running.
+func (c *Client) Stream(ctx context.Context, pattern string, data interface{}) (*ClientStream, error) {
+	id, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+
+	st := &ClientStream{client: c, id: id, notify: make(chan struct{}, 1)}
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return nil, c.closeErr
+	}
+	c.streams[id] = st
+	c.mu.Unlock()
+
+	payload, err := c.codec.Marshal(newEnvelope(ctx, id, pattern, data))
+	if err != nil {
+		c.removeStream(id)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		c.removeStream(id)
+		return nil, err
+	}
+	if err := c.write(payload); err != nil {
+		c.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Recv returns the next value of the stream. It returns io.EOF once the server completed the
+// stream, or the *Error the server terminated it with.
+func (st *ClientStream) Recv(ctx context.Context) (json.RawMessage, error) {
+	for {
+		st.mu.Lock()
+		if len(st.queue) > 0 {
+			data := st.queue[0]
+			st.queue[0] = nil
+			st.queue = st.queue[1:]
+			st.mu.Unlock()
+			return data, nil
+		}
+		err := st.err
+		st.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-st.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close stops the stream, asking the server to cancel the request if it has not finished
+func (st *ClientStream) Close() error {
+	var err error
+	st.closeOnce.Do(func() {
+		st.client.removeStream(st.id)
+		st.mu.Lock()
+		finished := st.err != nil
+		if !finished {
+			st.err = ErrClientClosed
+		}
+		st.mu.Unlock()
+		if finished || st.client.isClosed() {
+			return
+		}
+		err = st.cancel()
+	})
+	return err
+}
+
+// cancel asks the server to stop the request
+func (st *ClientStream) cancel() error {
+	payload, err := st.client.codec.Marshal(map[string]interface{}{"id": st.id, "cancel": true})
+	if err != nil {
+		return err
+	}
+	return st.client.write(payload)
+}
+
+// push hands a frame to the stream; it reports whether the stream is finished
+func (st *ClientStream) push(resp clientResponse) bool {
+	st.mu.Lock()
+	switch {
+	case len(resp.Err) > 0 && string(resp.Err) != "null":
+		st.err = decodeRemoteError(resp.Err)
+	case resp.IsDisposed:
+		// Nest may put the last value on the disposal frame itself
+		if len(resp.Response) > 0 {
+			st.queue = append(st.queue, resp.Response)
+		}
+		st.err = io.EOF
+	case len(st.queue) >= st.client.streamBuf:
+		// the reader fell too far behind; stop the server instead of buffering without bound
+		st.err = ErrStreamBufferFull
+		go st.cancel()
+	default:
+		data := resp.Response
+		if len(data) == 0 {
+			data = json.RawMessage("null")
+		}
+		st.queue = append(st.queue, data)
+	}
+	done := st.err != nil
+	st.mu.Unlock()
+	st.wake()
+	return done
+}
+
+func (st *ClientStream) fail(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.mu.Unlock()
+	st.wake()
+}
+
+func (st *ClientStream) wake() {
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) removeStream(id string) {
+	c.mu.Lock()
+	delete(c.streams, id)
+	c.mu.Unlock()
+}
@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestClientVoidHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.RegisterHandler("void", func(json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, err := client.Send(ctx, "void", nil)
+	if err != nil {
+		t.Fatalf("Send = %v", err)
+	}
+	if string(out) != "null" {
+		t.Fatalf("Send = %s, want null", out)
+	}
+}
+
+func TestClientDeliver(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		want    string
+		wantErr bool
+		pending bool
+	}{
+		{name: "value", frame: `{"id":"1","response":{"a":1},"isDisposed":true}`, want: `{"a":1}`},
+		{name: "void with status", frame: `{"id":"1","status":"ok"}`, want: "null"},
+		{name: "void disposal", frame: `{"id":"1","isDisposed":true}`, want: "null"},
+		{name: "error", frame: `{"id":"1","err":{"status":"error","message":"boom"},"status":"error"}`, wantErr: true},
+		{name: "empty frame keeps waiting", frame: `{"id":"1"}`, pending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan clientResult, 1)
+			c := &Client{pending: map[string]chan clientResult{"1": ch}, streams: map[string]*ClientStream{}}
+			var resp clientResponse
+			if err := json.Unmarshal([]byte(tt.frame), &resp); err != nil {
+				t.Fatal(err)
+			}
+			c.deliver(resp)
+
+			select {
+			case res := <-ch:
+				if tt.pending {
+					t.Fatalf("call completed with %s, %v", res.data, res.err)
+				}
+				if (res.err != nil) != tt.wantErr {
+					t.Fatalf("err = %v, wantErr %t", res.err, tt.wantErr)
+				}
+				if !tt.wantErr && string(res.data) != tt.want {
+					t.Fatalf("data = %s, want %s", res.data, tt.want)
+				}
+			default:
+				if !tt.pending {
+					t.Fatal("call not completed")
+				}
+			}
+		})
+	}
+}
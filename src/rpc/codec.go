@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Codec encodes and decodes frame payloads. Request data stays in the wire encoding until
+// Context.Bind decodes it with the connection's codec; MessageHandlers and EventHandlers get
+// it converted to JSON. Values are encoded from their fields and json tags, and
+// json.RawMessage and *Error values keep their JSON form in the binary codecs.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecMatcher is implemented by codecs that can recognise their own encoding from the
+// first bytes of a payload, which is how the codec of a connection is negotiated
+type codecMatcher interface {
+	Match(payload []byte) bool
+}
+
+// envelope is a request envelope whose values are still in the codec's encoding
+type envelope struct {
+	ID       []byte
+	Pattern  []byte
+	Data     []byte
+	Metadata []byte
+	Cancel   bool
+}
+
+// envelopeDecoder is implemented by codecs that can split an envelope into its raw values;
+// other codecs go through a generic map
+type envelopeDecoder interface {
+	decodeEnvelope(payload []byte) (*envelope, error)
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+	CBOR    Codec = cborCodec{}
+)
+
+// maxDecodeDepth bounds nesting so hostile payloads cannot exhaust the stack
+const maxDecodeDepth = 1000
+
+var errMaxDepth = errors.New("payload nested too deeply")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "JSON" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) Match(payload []byte) bool {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func (jsonCodec) decodeEnvelope(payload []byte) (*envelope, error) {
+	var raw struct {
+		ID       json.RawMessage `json:"id"`
+		Pattern  json.RawMessage `json:"pattern"`
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+		Cancel   bool            `json:"cancel"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	return &envelope{ID: raw.ID, Pattern: raw.Pattern, Data: raw.Data, Metadata: raw.Metadata, Cancel: raw.Cancel}, nil
+}
+
+// detectCodec picks the codec of a connection from its first payload
+func detectCodec(payload []byte, candidates []Codec, fallback Codec) Codec {
+	for _, codec := range candidates {
+		if m, ok := codec.(codecMatcher); ok && m.Match(payload) {
+			return codec
+		}
+	}
+	return fallback
+}
+
+func decodeEnvelope(codec Codec, payload []byte) (*envelope, error) {
+	if d, ok := codec.(envelopeDecoder); ok {
+		return d.decodeEnvelope(payload)
+	}
+	var fields map[string]interface{}
+	if err := codec.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	env := &envelope{}
+	env.Cancel, _ = fields["cancel"].(bool)
+	for key, raw := range map[string]*[]byte{"id": &env.ID, "pattern": &env.Pattern, "data": &env.Data, "metadata": &env.Metadata} {
+		value, ok := fields[key]
+		if !ok {
+			continue
+		}
+		encoded, err := codec.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		*raw = encoded
+	}
+	return env, nil
+}
+
+// decodeJSONValue decodes JSON into generic values for the binary codecs, keeping integers
+// as int64 or uint64 so they are not re-encoded as floats
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(out), nil
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			return u
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = normalizeNumbers(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = normalizeNumbers(t[k])
+		}
+	}
+	return v
+}
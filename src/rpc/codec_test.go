@@ -0,0 +1,508 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var binaryCodecs = []Codec{MsgPack, CBOR}
+
+// codecSamples covers the value shapes at the size boundaries where the binary encodings
+// switch to a longer header
+func codecSamples() map[string]interface{} {
+	array := func(n int) []interface{} {
+		out := make([]interface{}, n)
+		for i := range out {
+			out[i] = int64(i)
+		}
+		return out
+	}
+	object := func(n int) map[string]interface{} {
+		out := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			out[strings.Repeat("k", i+1)] = int64(i)
+		}
+		return out
+	}
+	return map[string]interface{}{
+		"nil":          nil,
+		"true":         true,
+		"false":        false,
+		"zero":         int64(0),
+		"uint8":        int64(128),
+		"uint16":       int64(256),
+		"uint32":       int64(math.MaxUint16 + 1),
+		"uint64":       int64(math.MaxUint32 + 1),
+		"uint64 max":   uint64(math.MaxUint64),
+		"negfixint":    int64(-32),
+		"int8":         int64(-33),
+		"int64 min":    int64(math.MinInt64),
+		"float":        1.5,
+		"float big":    math.MaxFloat64,
+		"empty string": "",
+		"str8":         strings.Repeat("s", 32),
+		"str16":        strings.Repeat("s", 256),
+		"str32":        strings.Repeat("s", math.MaxUint16+1),
+		"unicode":      "héllo, 世界",
+		"bytes":        []byte{0, 1, 2, 0xff},
+		"empty array":  []interface{}{},
+		"array16":      array(16),
+		"array32":      array(math.MaxUint16 + 1),
+		"empty map":    map[string]interface{}{},
+		"map16":        object(16),
+		"nested": map[string]interface{}{
+			"id":      "1",
+			"pattern": map[string]interface{}{"cmd": "users.get"},
+			"data":    []interface{}{int64(1), "two", 3.5, nil, true, map[string]interface{}{"x": []interface{}{}}},
+		},
+	}
+}
+
+// sameJSON compares values by their JSON form, since the codecs pick their own integer types
+func sameJSON(t *testing.T, got, want interface{}) bool {
+	t.Helper()
+	a, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(a, b)
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	for _, codec := range binaryCodecs {
+		for name, value := range codecSamples() {
+			t.Run(codec.Name()+"/"+name, func(t *testing.T) {
+				encoded, err := codec.Marshal(value)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				var decoded interface{}
+				if err := codec.Unmarshal(encoded, &decoded); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if !sameJSON(t, decoded, value) {
+					t.Fatalf("round trip = %#v, want %#v", decoded, value)
+				}
+			})
+		}
+	}
+}
+
+func TestBinaryCodecStructs(t *testing.T) {
+	type pattern struct {
+		Cmd string `json:"cmd"`
+	}
+	type envelope struct {
+		ID      string            `json:"id"`
+		Pattern pattern           `json:"pattern"`
+		Data    []int             `json:"data"`
+		Meta    map[string]string `json:"metadata,omitempty"`
+		Skipped string            `json:"-"`
+	}
+	in := envelope{ID: "42", Pattern: pattern{Cmd: "sum"}, Data: []int{1, -2, 300}, Meta: map[string]string{"k": "v"}, Skipped: "x"}
+
+	for _, codec := range binaryCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			encoded, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out envelope
+			if err := codec.Unmarshal(encoded, &out); err != nil {
+				t.Fatal(err)
+			}
+			want := in
+			want.Skipped = ""
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("got %+v, want %+v", out, want)
+			}
+
+			var asJSON json.RawMessage
+			if err := codec.Unmarshal(encoded, &asJSON); err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"data":[1,-2,300],"id":"42","metadata":{"k":"v"},"pattern":{"cmd":"sum"}}`; string(asJSON) != want {
+				t.Fatalf("as JSON = %s, want %s", asJSON, want)
+			}
+
+			if err := codec.Unmarshal(encoded, out); err == nil {
+				t.Fatal("Unmarshal into a non-pointer succeeded")
+			}
+		})
+	}
+}
+
+// TestBinaryCodecJSONValues checks that json.RawMessage and *Error values are carried in the
+// binary encoding rather than as opaque bytes, in both directions
+func TestBinaryCodecJSONValues(t *testing.T) {
+	for _, codec := range binaryCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			encoded, err := codec.Marshal(Response{
+				Id:       "1",
+				Response: json.RawMessage(`{"n":1,"big":18446744073709551615,"f":0.5,"list":["a",null]}`),
+				Err:      &Error{Code: CodeInvalidArgument, Message: "bad", Details: map[string]interface{}{"field": "name"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var generic map[string]interface{}
+			if err := codec.Unmarshal(encoded, &generic); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]interface{}{
+				"id":       "1",
+				"response": map[string]interface{}{"n": 1, "big": uint64(math.MaxUint64), "f": 0.5, "list": []interface{}{"a", nil}},
+				"err":      map[string]interface{}{"status": CodeInvalidArgument, "message": "bad", "field": "name"},
+			}
+			if !sameJSON(t, generic, want) {
+				t.Fatalf("decoded %#v, want %#v", generic, want)
+			}
+
+			var resp clientResponse
+			if err := codec.Unmarshal(encoded, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"big":18446744073709551615,"f":0.5,"list":["a",null],"n":1}`; string(resp.Response) != want {
+				t.Fatalf("response = %s, want %s", resp.Response, want)
+			}
+			var rpcErr Error
+			if err := json.Unmarshal(resp.Err, &rpcErr); err != nil {
+				t.Fatal(err)
+			}
+			if rpcErr.Code != CodeInvalidArgument || rpcErr.Message != "bad" {
+				t.Fatalf("err = %+v", rpcErr)
+			}
+		})
+	}
+}
+
+// TestBinaryCodecKnownEncodings pins the wire format against encodings from the specs
+func TestBinaryCodecKnownEncodings(t *testing.T) {
+	tests := []struct {
+		codec Codec
+		hex   string
+		want  string
+	}{
+		{MsgPack, "81a16101", `{"a":1}`},
+		{MsgPack, "93c0c2c3", `[null,false,true]`},
+		{MsgPack, "cd0100", `256`},
+		{MsgPack, "d0df", `-33`},
+		{MsgPack, "cfffffffffffffffff", `18446744073709551615`},
+		{MsgPack, "ca3fc00000", `1.5`},
+		{MsgPack, "cb3ff8000000000000", `1.5`},
+		{MsgPack, "c403010203", `"AQID"`},
+		{MsgPack, "d90161", `"a"`},
+		{MsgPack, "dc0001c0", `[null]`},
+		{MsgPack, "de0001a16101", `{"a":1}`},
+		{CBOR, "a1616101", `{"a":1}`},
+		{CBOR, "83f6f4f5", `[null,false,true]`},
+		{CBOR, "190100", `256`},
+		{CBOR, "3820", `-33`},
+		{CBOR, "1bffffffffffffffff", `18446744073709551615`},
+		{CBOR, "f93c00", `1`},
+		{CBOR, "f9c000", `-2`},
+		{CBOR, "fa3fc00000", `1.5`},
+		{CBOR, "f7", `null`},
+		{CBOR, "4401020304", `"AQIDBA=="`},
+		// indefinite-length items
+		{CBOR, "9f0102ff", `[1,2]`},
+		{CBOR, "7f6261626163ff", `"abc"`},
+		{CBOR, "bf616101ff", `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec.Name()+"/"+tt.hex, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got json.RawMessage
+			if err := tt.codec.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryCodecTruncation(t *testing.T) {
+	for _, codec := range binaryCodecs {
+		for name, value := range codecSamples() {
+			encoded, err := codec.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the big samples would make this quadratic; their headers are what matters
+			limit := len(encoded)
+			if limit > 300 {
+				limit = 300
+			}
+			var v interface{}
+			for i := 0; i < limit; i++ {
+				if err := codec.Unmarshal(encoded[:i], &v); err == nil {
+					t.Fatalf("%s/%s: decoding %d of %d bytes succeeded", codec.Name(), name, i, len(encoded))
+				}
+			}
+			if err := codec.Unmarshal(append(encoded[:len(encoded):len(encoded)], 0x00), &v); err == nil {
+				t.Fatalf("%s/%s: trailing byte accepted", codec.Name(), name)
+			}
+		}
+	}
+}
+
+// TestBinaryCodecHostileInput checks that lengths claiming more data than the payload holds are
+// rejected before anything is allocated for them, and that deep nesting is bounded
+func TestBinaryCodecHostileInput(t *testing.T) {
+	deep := func(prefix byte, leaf byte, n int) []byte {
+		return append(bytes.Repeat([]byte{prefix}, n), leaf)
+	}
+	tests := []struct {
+		name  string
+		codec Codec
+		data  []byte
+		want  error
+	}{
+		{"msgpack array32 count", MsgPack, []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0}, errMsgpackShort},
+		{"msgpack map32 count", MsgPack, []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0xc0, 0xc0}, errMsgpackShort},
+		{"msgpack str32 length", MsgPack, []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}, errMsgpackShort},
+		{"msgpack bin32 length", MsgPack, []byte{0xc6, 0xff, 0xff, 0xff, 0xff, 'a'}, errMsgpackShort},
+		{"msgpack ext32 length", MsgPack, []byte{0xc9, 0xff, 0xff, 0xff, 0xff, 0x01, 'a'}, errMsgpackShort},
+		{"msgpack fixmap count", MsgPack, []byte{0x8f, 0xc0}, errMsgpackShort},
+		{"msgpack nesting", MsgPack, deep(0x91, 0xc0, maxDecodeDepth+10), errMaxDepth},
+		{"msgpack nested maps", MsgPack, deep(0x81, 0xc0, maxDecodeDepth+10), errMaxDepth},
+		{"cbor array count", CBOR, []byte{0x9b, 0x40, 0, 0, 0, 0, 0, 0, 0, 0xf6}, nil},
+		{"cbor map count", CBOR, []byte{0xbb, 0x40, 0, 0, 0, 0, 0, 0, 0, 0xf6, 0xf6}, nil},
+		{"cbor text length", CBOR, []byte{0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'}, nil},
+		{"cbor bytes length", CBOR, []byte{0x5b, 0x80, 0, 0, 0, 0, 0, 0, 0, 'a'}, nil},
+		{"cbor unterminated indefinite array", CBOR, []byte{0x9f, 0x01}, nil},
+		{"cbor nesting", CBOR, deep(0x81, 0xf6, maxDecodeDepth+10), nil},
+		{"cbor nested tags", CBOR, deep(0xc1, 0xf6, maxDecodeDepth+10), nil},
+		{"cbor nested indefinite arrays", CBOR, deep(0x9f, 0xf6, maxDecodeDepth+10), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			err := tt.codec.Unmarshal(tt.data, &v)
+			if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Unmarshal = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryCodecRejectsInvalidInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		hex   string
+	}{
+		{"msgpack never used", MsgPack, "c1"},
+		{"msgpack empty", MsgPack, ""},
+		{"cbor reserved additional info", CBOR, "1c"},
+		{"cbor indefinite uint", CBOR, "1f"},
+		{"cbor indefinite tag", CBOR, "df"},
+		{"cbor mixed string chunks", CBOR, "7f4161ff"},
+		{"cbor nested indefinite chunk", CBOR, "7f7fffff"},
+		{"cbor empty", CBOR, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if err := tt.codec.Unmarshal(data, &v); err == nil {
+				t.Fatalf("Unmarshal(%s) = %#v, want an error", tt.hex, v)
+			}
+		})
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	for _, codec := range []Codec{JSON, MsgPack, CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			encode := func(v interface{}) []byte {
+				t.Helper()
+				data, err := codec.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return data
+			}
+
+			req, err := parseRequest(codec, encode(map[string]interface{}{
+				"id":       "7",
+				"pattern":  map[string]interface{}{"cmd": "geo.move"},
+				"data":     point{X: 1, Y: -2},
+				"metadata": map[string]string{"traceparent": "00-abc"},
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.ID != "7" || req.Pattern.Cmd != "geo.move" || req.Metadata["traceparent"] != "00-abc" {
+				t.Fatalf("request = %+v", req)
+			}
+			if (req.Data == nil) != (codec != JSON) {
+				t.Fatalf("Data = %s with %s", req.Data, codec.Name())
+			}
+			var p point
+			if err := (&Context{Request: req}).Bind(&p); err != nil || p != (point{X: 1, Y: -2}) {
+				t.Fatalf("Bind = %+v, %v", p, err)
+			}
+			data, err := req.jsonData()
+			if err != nil || string(data) != `{"x":1,"y":-2}` {
+				t.Fatalf("jsonData = %s, %v", data, err)
+			}
+
+			// middleware rewriting Data wins over the wire encoding
+			req.Data = json.RawMessage(`{"x":5}`)
+			if err := (&Context{Request: req}).Bind(&p); err != nil || p.X != 5 {
+				t.Fatalf("Bind after rewrite = %+v, %v", p, err)
+			}
+
+			req, err = parseRequest(codec, encode(map[string]interface{}{
+				"id":       "8",
+				"pattern":  `{"cmd":"as.string"}`,
+				"metadata": []int{1},
+			}))
+			if err != nil || req.Pattern.Cmd != "as.string" || req.Metadata != nil {
+				t.Fatalf("string pattern = %+v, %v", req, err)
+			}
+			if err := (&Context{Request: req}).Bind(&p); err != nil {
+				t.Fatalf("Bind without data = %v", err)
+			}
+
+			req, err = parseRequest(codec, encode(map[string]interface{}{"id": "9", "cancel": true}))
+			if err != nil || !req.Cancel || req.ID != "9" {
+				t.Fatalf("cancel = %+v, %v", req, err)
+			}
+
+			bad := encode(map[string]interface{}{"id": 12, "pattern": map[string]interface{}{"cmd": "x"}})
+			if _, err := parseRequest(codec, bad); err == nil {
+				t.Fatal("numeric id accepted")
+			}
+			if id := extractRequestID(codec, bad); id != "12" {
+				t.Fatalf("extractRequestID = %q, want 12", id)
+			}
+			if _, err := parseRequest(codec, encode(map[string]interface{}{"id": "10", "pattern": 3})); err == nil {
+				t.Fatal("invalid pattern accepted")
+			}
+		})
+	}
+}
+
+func TestBinaryCodecEndToEnd(t *testing.T) {
+	type sum struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, Codecs: []Codec{JSON, MsgPack, CBOR}})
+	s.RegisterHandler("echo", func(data json.RawMessage) (interface{}, error) { return data, nil })
+	s.RegisterContextHandler("sum", Typed(func(ctx *Context, in sum) (interface{}, error) {
+		return map[string]int{"sum": in.A + in.B}, nil
+	}))
+	s.RegisterHandler("fail", func(json.RawMessage) (interface{}, error) {
+		return nil, Errorf(CodeNotFound, "no such thing")
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+
+	for _, codec := range []Codec{JSON, MsgPack, CBOR} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			client, err := Dial(context.Background(), &ClientConfig{Addr: addr, Codec: codec})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			out, err := client.Send(context.Background(), "echo", map[string]interface{}{"n": 1, "s": "x"})
+			if err != nil || string(out) != `{"n":1,"s":"x"}` {
+				t.Fatalf("echo = %s, %v", out, err)
+			}
+			out, err = client.Send(context.Background(), "sum", sum{A: 2, B: 40})
+			if err != nil || string(out) != `{"sum":42}` {
+				t.Fatalf("sum = %s, %v", out, err)
+			}
+			_, err = client.Send(context.Background(), "fail", nil)
+			var rpcErr *Error
+			if !errors.As(err, &rpcErr) || rpcErr.Code != CodeNotFound || rpcErr.Message != "no such thing" {
+				t.Fatalf("fail = %v", err)
+			}
+		})
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	all := []Codec{JSON, MsgPack, CBOR}
+	mustEncode := func(codec Codec) []byte {
+		data, err := codec.Marshal(map[string]interface{}{"id": "1", "pattern": "p"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tests := []struct {
+		name       string
+		payload    []byte
+		candidates []Codec
+		want       Codec
+	}{
+		{"json", []byte(`{"id":"1"}`), all, JSON},
+		{"json with leading whitespace", []byte("\n\t {\"id\":\"1\"}"), all, JSON},
+		{"msgpack", mustEncode(MsgPack), all, MsgPack},
+		{"msgpack map16", append([]byte{0xde, 0x00, 0x10}, make([]byte, 32)...), all, MsgPack},
+		{"cbor", mustEncode(CBOR), all, CBOR},
+		{"unknown falls back", []byte{0x01}, all, JSON},
+		{"empty falls back", nil, all, JSON},
+		{"codec not offered", mustEncode(CBOR), []Codec{JSON, MsgPack}, JSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectCodec(tt.payload, tt.candidates, JSON); got != tt.want {
+				t.Fatalf("detectCodec = %s, want %s", got.Name(), tt.want.Name())
+			}
+		})
+	}
+}
+
+func fuzzCodec(f *testing.F, codec Codec) {
+	for _, value := range codecSamples() {
+		if data, err := codec.Marshal(value); err == nil && len(data) < 1024 {
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := codec.Unmarshal(data, &v); err != nil {
+			return
+		}
+		// whatever decodes must encode again
+		if _, err := codec.Marshal(v); err != nil {
+			t.Fatalf("re-encoding %#v: %v", v, err)
+		}
+		// nor may splitting it as an envelope
+		_, _ = parseRequest(codec, data)
+	})
+}
+
+func FuzzMsgpackUnmarshal(f *testing.F) { fuzzCodec(f, MsgPack) }
+
+func FuzzCBORUnmarshal(f *testing.F) { fuzzCodec(f, CBOR) }
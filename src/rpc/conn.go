@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errFramingUnknown is returned while the framing of an auto-detected connection is not yet
+// known, i.e. before the peer sent its first bytes
+var errFramingUnknown = errors.New("framing not negotiated yet")
+
+// connection is the server-side state of a single client connection
+type connection struct {
+	id         string
+	server     *Server
+	conn       net.Conn
+	ctx        context.Context
+	cancel     context.CancelFunc
+	sem        chan struct{}
+	inflight   sync.WaitGroup
+	out        chan []byte
+	quit       chan struct{}
+	quitOnce   sync.Once
+	writerDone chan struct{}
+	slowOnce   sync.Once
+	protoMu    sync.RWMutex
+	codec      Codec
+	negotiated bool
+	framer     Framer
+	peer       *PeerIdentity
+	callsMu    sync.Mutex
+	calls      map[string]*call
+	limiter    *rate.Limiter
+	draining   atomic.Bool
+	// idle evicts the connection after HeartbeatTimeout without frames or in-flight requests
+	idle *time.Timer
+}
+
+// call is an in-flight request the client may cancel by id
+type call struct {
+	cancel context.CancelFunc
+}
+
+func (s *Server) newConnection(conn net.Conn) *connection {
+	ctx, cancel := context.WithCancel(s.ctx)
+	var limiter *rate.Limiter
+	if s.config.RateLimitPerSec > 0 {
+		limiter = rate.NewLimiter(rate.Limit(s.config.RateLimitPerSec), s.config.RateLimitBurst)
+	}
+	return &connection{
+		id:         fmt.Sprintf("conn-%d", s.nextConnID.Add(1)),
+		server:     s,
+		conn:       conn,
+		ctx:        ctx,
+		cancel:     cancel,
+		sem:        make(chan struct{}, s.config.MaxInFlightPerConn),
+		out:        make(chan []byte, s.config.WriteQueueSize),
+		quit:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+		codec:      s.config.Codec,
+		calls:      make(map[string]*call),
+		limiter:    limiter,
+	}
+}
+
+// negotiateCodec fixes the connection's codec from its first payload when the server
+// accepts several codecs; later frames must use the same one
+func (c *connection) negotiateCodec(payload []byte) Codec {
+	c.protoMu.Lock()
+	defer c.protoMu.Unlock()
+	if !c.negotiated {
+		c.codec = detectCodec(payload, c.server.config.Codecs, c.server.config.Codec)
+		c.negotiated = true
+	}
+	return c.codec
+}
+
+func (c *connection) setFramer(framer Framer) {
+	c.protoMu.Lock()
+	defer c.protoMu.Unlock()
+	c.framer = framer
+}
+
+// encode marshals v with the connection's codec and frames it for the wire
+func (c *connection) encode(v interface{}) ([]byte, error) {
+	c.protoMu.RLock()
+	codec, framer := c.codec, c.framer
+	c.protoMu.RUnlock()
+
+	if framer == nil {
+		return nil, errFramingUnknown
+	}
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return framer.WriteFrame(payload), nil
+}
+
+// trackCall makes the request cancellable by a cancel frame; the returned func untracks it
+func (c *connection) trackCall(id string, cancel context.CancelFunc) func() {
+	cl := &call{cancel: cancel}
+	c.callsMu.Lock()
+	c.calls[id] = cl
+	c.callsMu.Unlock()
+	return func() {
+		c.callsMu.Lock()
+		// a reused id may already belong to a newer call
+		if c.calls[id] == cl {
+			delete(c.calls, id)
+		}
+		c.callsMu.Unlock()
+	}
+}
+
+// cancelCall cancels the in-flight request with the given id, if any
+func (c *connection) cancelCall(id string) bool {
+	c.callsMu.Lock()
+	cl, ok := c.calls[id]
+	c.callsMu.Unlock()
+	if ok {
+		cl.cancel()
+	}
+	return ok
+}
+
+// slot is one of a connection's MaxInFlightPerConn slots. It is freed once every holder let
+// go, so a handler still running after its request timed out keeps counting against the limit.
+type slot struct {
+	c    *connection
+	refs atomic.Int32
+}
+
+func (sl *slot) hold() { sl.refs.Add(1) }
+
+func (sl *slot) release() {
+	if sl.refs.Add(-1) == 0 {
+		<-sl.c.sem
+		// the peer may have sent nothing while it waited for this answer
+		sl.c.idle.Reset(sl.c.server.config.HeartbeatTimeout)
+	}
+}
+
+// dispatch runs fn on its own goroutine, waiting for a free in-flight slot first.
+// It returns false if the connection closed while waiting.
+func (c *connection) dispatch(fn func(sl *slot)) bool {
+	select {
+	case c.sem <- struct{}{}:
+	case <-c.ctx.Done():
+		return false
+	}
+
+	sl := &slot{c: c}
+	sl.hold()
+	c.inflight.Add(1)
+	go func() {
+		defer func() {
+			sl.release()
+			c.inflight.Done()
+		}()
+		fn(sl)
+	}()
+	return true
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"context"
+	"net"
+)
+
+// ContextHandler is the full handler signature; MessageHandler is adapted onto it
+type ContextHandler func(ctx *Context) (interface{}, error)
+
+// EventContextHandler is the full event handler signature; EventHandler is adapted onto it
+type EventContextHandler func(ctx *Context) error
+
+// Context carries request metadata to handlers. The embedded context.Context is
+// cancelled when the connection drops or the server shuts down.
+type Context struct {
+	context.Context
+	Request    *Request
+	ConnID     string
+	RemoteAddr net.Addr
+	// Peer is the verified client certificate identity; nil unless mutual TLS verified one
+	Peer *PeerIdentity
+
+	stream *Stream
+}
+
+// Bind decodes the request data into v, straight from the wire encoding with binary codecs
+func (c *Context) Bind(v interface{}) error {
+	return c.Request.bind(v)
+}
+
+// Typed builds a ContextHandler that decodes the request data into T before calling handler
+func Typed[T any](handler func(ctx *Context, in T) (interface{}, error)) ContextHandler {
+	return func(ctx *Context) (interface{}, error) {
+		var in T
+		if err := ctx.Bind(&in); err != nil {
+			return nil, Errorf(CodeInvalidArgument, "Invalid request data: %v", err)
+		}
+		return handler(ctx, in)
+	}
+}
+
+// AdaptHandler wraps a MessageHandler so it can be used wherever a ContextHandler is expected
+func AdaptHandler(handler MessageHandler) ContextHandler {
+	return func(ctx *Context) (interface{}, error) {
+		data, err := ctx.Request.jsonData()
+		if err != nil {
+			return nil, Errorf(CodeInvalidArgument, "Invalid request data: %v", err)
+		}
+		return handler(data)
+	}
+}
+
+// AdaptEventHandler wraps an EventHandler so it can be used wherever an EventContextHandler is expected
+func AdaptEventHandler(handler EventHandler) EventContextHandler {
+	return func(ctx *Context) error {
+		data, err := ctx.Request.jsonData()
+		if err != nil {
+			return Errorf(CodeInvalidArgument, "Invalid request data: %v", err)
+		}
+		return handler(data)
+	}
+}
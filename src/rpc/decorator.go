@@ -6,6 +6,9 @@ import (
 
 type MessageHandler func(data json.RawMessage) (interface{}, error)
 
+// EventHandler handles fire-and-forget events; nothing is sent back to the caller
+type EventHandler func(data json.RawMessage) error
+
 type ServerWrapper struct {
 	server *Server
 }
@@ -14,6 +17,25 @@ func NewServerWrapper(server *Server) *ServerWrapper {
 	return &ServerWrapper{server: server}
 }
 
-func (w *ServerWrapper) MessagePattern(pattern string, handler MessageHandler) {
-	w.server.RegisterHandler(pattern, handler)
+func (w *ServerWrapper) MessagePattern(pattern string, handler MessageHandler, opts ...PatternOption) {
+	w.server.RegisterHandler(pattern, handler, opts...)
+}
+
+// MessagePatternWithContext registers a handler that also receives request metadata
+func (w *ServerWrapper) MessagePatternWithContext(pattern string, handler ContextHandler, opts ...PatternOption) {
+	w.server.RegisterContextHandler(pattern, handler, opts...)
+}
+
+// MessagePatternStream registers a handler that replies with a stream of values
+func (w *ServerWrapper) MessagePatternStream(pattern string, handler StreamHandler, opts ...PatternOption) {
+	w.server.RegisterStreamHandler(pattern, handler, opts...)
+}
+
+func (w *ServerWrapper) EventPattern(pattern string, handler EventHandler) {
+	w.server.RegisterEventHandler(pattern, handler)
+}
+
+// EventPatternWithContext registers an event handler that also receives request metadata
+func (w *ServerWrapper) EventPatternWithContext(pattern string, handler EventContextHandler) {
+	w.server.RegisterEventContextHandler(pattern, handler)
 }
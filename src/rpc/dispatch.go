@@ -0,0 +1,225 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errRequestTimeout = errors.New("request timed out")
+
+type handlerResult struct {
+	result interface{}
+	err    error
+}
+
+// handleRequest runs the handler registered for req under the request deadline and replies
+func (s *Server) handleRequest(c *connection, req *Request, sl *slot) {
+	conn := c.conn
+
+	s.metrics.mu.Lock()
+	s.metrics.RequestsTotal++
+	s.metrics.mu.Unlock()
+
+	s.logger.Debug("Received request", c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID)...)
+
+	rt, ok := s.registry.route(req.Pattern.Cmd)
+	if !ok {
+		s.metrics.mu.Lock()
+		s.metrics.ErrorsTotal++
+		s.metrics.mu.Unlock()
+		s.sendError(c, req.Pattern.Cmd, req.ID, NotFound("Unknown pattern"))
+		return
+	}
+
+	stats := s.stats.pattern(req.Pattern.Cmd)
+	stats.begin()
+	start := time.Now()
+	statusCode, statusMessage := codeClientClosed, "Request cancelled"
+	defer func() {
+		elapsed := time.Since(start)
+		stats.end(s.stats.bounds, elapsed, statusCode)
+		s.logger.Debug("Request completed",
+			c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID, "duration", elapsed, "status", statusCode)...)
+	}()
+
+	parent := c.ctx
+	if span := s.startServerSpan(c, req, "server"); span != nil {
+		parent = ContextWithSpan(parent, span)
+		defer func() { endServerSpan(span, statusCode, statusMessage) }()
+	}
+
+	// the breaker judges what the caller got, so a timeout counts even if the handler finishes later
+	var outcome error
+	completed := false
+	if rt.breaker != nil {
+		retryAfter, ok := rt.breaker.allow()
+		if !ok {
+			rpcErr := rt.breaker.rejection(retryAfter)
+			s.metrics.mu.Lock()
+			s.metrics.ErrorsTotal++
+			s.metrics.mu.Unlock()
+			statusCode, statusMessage = rpcErr.Code, rpcErr.Message
+			s.sendError(c, req.Pattern.Cmd, req.ID, rpcErr)
+			return
+		}
+		defer func() {
+			if completed {
+				rt.breaker.record(outcome)
+			} else {
+				rt.breaker.release()
+			}
+		}()
+	}
+
+	timeout := s.config.Timeout
+	if rt.timeout > 0 {
+		timeout = rt.timeout
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if rt.stream && rt.timeout <= 0 {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	}
+	defer cancel()
+	defer c.trackCall(req.ID, cancel)()
+
+	hctx := &Context{
+		Context:    ctx,
+		Request:    req,
+		ConnID:     c.id,
+		RemoteAddr: conn.RemoteAddr(),
+		Peer:       c.peer,
+	}
+	if rt.stream {
+		hctx.stream = newStream(c, hctx)
+	}
+	result, err := s.invoke(hctx, s.buildHandler(rt), sl)
+	if hctx.stream != nil {
+		hctx.stream.close()
+	}
+
+	// nobody left to answer once the connection is gone or the client cancelled
+	if c.ctx.Err() != nil {
+		return
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+
+	var rpcErr *Error
+	if errors.Is(err, errRequestTimeout) {
+		s.metrics.mu.Lock()
+		s.metrics.ErrorsTotal++
+		s.metrics.TimeoutsTotal++
+		s.metrics.mu.Unlock()
+		s.logger.Warn("Handler timed out",
+			c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID, "timeout", timeout)...)
+		rpcErr = Timeout(fmt.Sprintf("Request timed out after %s", timeout))
+		outcome = rpcErr
+	} else if err != nil {
+		s.metrics.mu.Lock()
+		s.metrics.ErrorsTotal++
+		s.metrics.mu.Unlock()
+		rpcErr = s.toRPCError(err)
+		outcome = err
+	}
+	completed = true
+
+	statusCode, statusMessage = 0, ""
+	if rpcErr != nil {
+		statusCode, statusMessage = rpcErr.Code, rpcErr.Message
+	}
+
+	if rt.stream {
+		hctx.stream.finish(rpcErr)
+		return
+	}
+	if rpcErr != nil {
+		s.sendError(c, req.Pattern.Cmd, req.ID, rpcErr)
+		return
+	}
+	s.sendResponse(c, req.Pattern.Cmd, Response{Response: result, Id: req.ID, Status: "ok", IsDisposed: true})
+}
+
+// invoke runs handler in its own goroutine so the caller can give up once the deadline passes.
+// The handler keeps running in the background but sees its context cancelled; it holds on to
+// the in-flight slot sl until it returns.
+func (s *Server) invoke(ctx *Context, handler ContextHandler, sl *slot) (interface{}, error) {
+	done := make(chan handlerResult, 1)
+	sl.hold()
+	go func() {
+		var res handlerResult
+		defer sl.release()
+		defer func() { done <- res }()
+		defer s.recoverPanic(ctx, &res.err)
+		res.result, res.err = handler(ctx)
+	}()
+
+	select {
+	case res := <-done:
+		return res.result, res.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, errRequestTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// handleEvent runs every handler subscribed to the event pattern without writing a response.
+// Like requests, the handlers run under invoke, so a handler that ignores its context cannot
+// keep the connection open once it is cancelled.
+func (s *Server) handleEvent(c *connection, req *Request, sl *slot) {
+	conn := c.conn
+	s.metrics.mu.Lock()
+	s.metrics.EventsTotal++
+	s.metrics.mu.Unlock()
+
+	handlers, ok := s.registry.GetEventContext(req.Pattern.Cmd)
+	if !ok {
+		s.logger.Debug("No handler for event", c.logAttrs("pattern", req.Pattern.Cmd)...)
+		return
+	}
+
+	s.logger.Debug("Received event", c.logAttrs("pattern", req.Pattern.Cmd)...)
+
+	span := s.startServerSpan(c, req, "consumer")
+	var failure error
+	defer func() {
+		if failure != nil {
+			endServerSpan(span, CodeInternal, failure.Error())
+		} else {
+			endServerSpan(span, 0, "")
+		}
+	}()
+
+	parent := c.ctx
+	if span != nil {
+		parent = ContextWithSpan(parent, span)
+	}
+	ctx := &Context{
+		Context:    parent,
+		Request:    req,
+		ConnID:     c.id,
+		RemoteAddr: conn.RemoteAddr(),
+		Peer:       c.peer,
+	}
+	for _, handler := range handlers {
+		_, err := s.invoke(ctx, func(ctx *Context) (interface{}, error) { return nil, handler(ctx) }, sl)
+		if c.ctx.Err() != nil {
+			failure = c.ctx.Err()
+			return
+		}
+		if err != nil {
+			failure = err
+			s.metrics.mu.Lock()
+			s.metrics.ErrorsTotal++
+			s.metrics.mu.Unlock()
+			s.logger.Error("Event handler failed", c.logAttrs("pattern", req.Pattern.Cmd, "error", err)...)
+		}
+	}
+}
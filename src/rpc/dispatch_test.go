@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimedOutHandlersKeepTheirInFlightSlot(t *testing.T) {
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, MaxInFlightPerConn: 2})
+	var running, peak atomic.Int32
+	s.RegisterHandler("slow", func(json.RawMessage) (interface{}, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		return nil, nil
+	}, WithTimeout(10*time.Millisecond))
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := client.Send(ctx, "slow", nil); err == nil {
+				t.Error("slow call did not time out")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("%d handlers ran at once, want at most MaxInFlightPerConn (2)", got)
+	}
+}
+
+func TestRecoveredPanicsGetTheirOwnError(t *testing.T) {
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger})
+	ctx := &Context{Context: context.Background(), Request: &Request{ID: "1"}, RemoteAddr: &net.TCPAddr{}}
+	recovered := func() (err error) {
+		defer s.recoverPanic(ctx, &err)
+		panic("boom")
+	}
+
+	first, ok := recovered().(*Error)
+	if !ok || first.Code != CodeInternal {
+		t.Fatalf("recovered panic = %v, want an internal error", first)
+	}
+	first.WithDetail("mapped", true)
+
+	second := recovered().(*Error)
+	if second == first || second.Details != nil {
+		t.Fatalf("second panic shares the first one's error: %+v", second)
+	}
+}
+
+func TestIdleEvictionWaitsForInFlightRequests(t *testing.T) {
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, HeartbeatTimeout: 100 * time.Millisecond})
+	s.RegisterHandler("slow", func(json.RawMessage) (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+		return "done", nil
+	}, WithTimeout(time.Second))
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+
+	client, err := Dial(context.Background(), &ClientConfig{Addr: addr, KeepAliveInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	out, err := client.Send(context.Background(), "slow", nil)
+	if err != nil || string(out) != `"done"` {
+		t.Fatalf("Send = %s, %v", out, err)
+	}
+	if n := s.GetMetrics().IdleEvictions; n != 0 {
+		t.Fatalf("IdleEvictions = %d while a request was in flight", n)
+	}
+
+	// once the answer is out, a silent peer is idle again
+	deadline := time.Now().Add(2 * time.Second)
+	for s.GetMetrics().IdleEvictions != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("silent connection was not evicted after its request finished")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
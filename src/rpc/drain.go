@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DrainNotifier is called for every open connection when Shutdown starts draining, before the
+// server stops reading from it, so clients can be told to reconnect elsewhere. Connections are
+// notified concurrently.
+type DrainNotifier func(conn *DrainingConn)
+
+// DrainingConn is a connection being drained by Shutdown
+type DrainingConn struct {
+	ConnID     string
+	RemoteAddr net.Addr
+	ctx        context.Context
+	c          *connection
+}
+
+// Notify sends v to the client using the connection's codec and framing. It waits for room in
+// the write queue until the Shutdown context is done.
+func (d *DrainingConn) Notify(v interface{}) error {
+	frame, err := d.c.encode(v)
+	if err != nil {
+		return err
+	}
+	return d.c.writeWait(d.ctx, frame)
+}
+
+func (s *Server) notifyDrain(ctx context.Context, c *connection) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("Panic in DrainNotifier", c.logAttrs("recovered", r)...)
+		}
+	}()
+	s.config.DrainNotifier(&DrainingConn{ConnID: c.id, RemoteAddr: c.conn.RemoteAddr(), ctx: ctx, c: c})
+}
+
+// stopReading makes the read loop exit before its next frame, unblocking a pending read.
+// Requests already dispatched still run to completion and get their responses.
+func (c *connection) stopReading() {
+	c.draining.Store(true)
+	c.conn.SetReadDeadline(time.Now())
+}
@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Status codes carried in Error.Code; they follow HTTP semantics so NestJS callers
+// can map them straight onto HttpException
+const (
+	CodeInvalidArgument = 400
+	CodeUnauthorized    = 401
+	CodeNotFound        = 404
+	CodeTimeout         = 408
+	CodePayloadTooLarge = 413
+	CodeTooManyRequests = 429
+	CodeInternal        = 500
+	CodeUnavailable     = 503
+)
+
+// Error is the equivalent of a NestJS RpcException. It is sent as the "err" object
+// of a response: {"status": Code, "message": Message, ...Details}.
+type Error struct {
+	Code    int
+	Message string
+	Details map[string]interface{}
+}
+
+// ErrorMapper converts arbitrary handler errors into structured errors.
+// Returning nil falls back to an internal error carrying err.Error().
+type ErrorMapper func(err error) *Error
+
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Errorf(code int, format string, args ...interface{}) *Error {
+	return NewError(code, fmt.Sprintf(format, args...))
+}
+
+func InvalidArgument(message string) *Error { return NewError(CodeInvalidArgument, message) }
+func Unauthorized(message string) *Error    { return NewError(CodeUnauthorized, message) }
+func NotFound(message string) *Error        { return NewError(CodeNotFound, message) }
+func Timeout(message string) *Error         { return NewError(CodeTimeout, message) }
+func Internal(message string) *Error        { return NewError(CodeInternal, message) }
+
+// WithDetail attaches an extra field to the serialized error
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Code == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	obj := make(map[string]interface{}, len(e.Details)+2)
+	for k, v := range e.Details {
+		obj[k] = v
+	}
+	obj["status"] = e.Code
+	obj["message"] = e.Message
+	return json.Marshal(obj)
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	// plain string errors, as sent by older servers
+	var msg string
+	if err := json.Unmarshal(data, &msg); err == nil {
+		*e = Error{Message: msg}
+		return nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*e = Error{}
+	if code, ok := obj["status"].(float64); ok {
+		e.Code = int(code)
+	}
+	if message, ok := obj["message"].(string); ok {
+		e.Message = message
+	}
+	delete(obj, "status")
+	delete(obj, "message")
+	if len(obj) > 0 {
+		e.Details = obj
+	}
+	return nil
+}
+
+// toRPCError resolves err to the structured error sent to the caller
+func (s *Server) toRPCError(err error) *Error {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if s.config.ErrorMapper != nil {
+		if mapped := s.config.ErrorMapper(err); mapped != nil {
+			return mapped
+		}
+	}
+	return Internal(err.Error())
+}
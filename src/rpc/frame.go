@@ -0,0 +1,246 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Framer splits the byte stream of a connection into payloads. ReadFrame must reject
+// frames larger than maxBytes before allocating for them, and should take its buffers
+// from getBuffer so the server can recycle them.
+type Framer interface {
+	Name() string
+	ReadFrame(r *bufio.Reader, maxBytes int) ([]byte, error)
+	WriteFrame(payload []byte) []byte
+}
+
+var (
+	// NestFramer is the NestJS TCP transport framing: "<decimal len>#<payload>"
+	NestFramer Framer = nestFramer{}
+	// NDJSONFramer is newline-delimited JSON; only usable with text codecs
+	NDJSONFramer Framer = ndjsonFramer{}
+	// BinaryFramer prefixes each payload with its length as a 4-byte big-endian integer
+	BinaryFramer Framer = binaryFramer{}
+)
+
+// frameError reports a frame the server refuses. Unless recoverable is set the stream
+// cannot be resynchronised afterwards.
+type frameError struct {
+	msg         string
+	code        int
+	recoverable bool
+}
+
+func (e *frameError) Error() string { return e.msg }
+
+func invalidFrame(format string, args ...interface{}) *frameError {
+	return &frameError{msg: fmt.Sprintf(format, args...), code: CodeInvalidArgument}
+}
+
+// frameTooLarge is returned after the oversized payload has been skipped, so reading can go on
+func frameTooLarge(size, maxBytes int) *frameError {
+	return &frameError{
+		msg:         fmt.Sprintf("Message of %d bytes exceeds the %d byte limit", size, maxBytes),
+		code:        CodePayloadTooLarge,
+		recoverable: true,
+	}
+}
+
+// pooledBufferSize is the capacity of recycled frame buffers; larger frames get a
+// one-off allocation so the pool never pins big chunks of memory
+const pooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, pooledBufferSize)
+		return &b
+	},
+}
+
+// getBuffer returns a buffer of length n, recycled when small enough
+func getBuffer(n int) []byte {
+	if n > pooledBufferSize {
+		return make([]byte, n)
+	}
+	b := bufferPool.Get().(*[]byte)
+	return (*b)[:n]
+}
+
+// putBuffer hands a buffer from getBuffer back for reuse; b must not be used afterwards
+func putBuffer(b []byte) {
+	if cap(b) != pooledBufferSize {
+		return
+	}
+	b = b[:cap(b)]
+	bufferPool.Put(&b)
+}
+
+// detectFramer picks the framing from the first byte a peer sends: a digit starts a NestJS
+// length prefix, '{' or whitespace starts NDJSON, anything else is a binary length prefix
+// (whose first byte is 0x00 for any frame under 16 MiB)
+func detectFramer(r *bufio.Reader) (Framer, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := b[0]; {
+	case c >= '0' && c <= '9':
+		return NestFramer, nil
+	case c == '{' || c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		return NDJSONFramer, nil
+	default:
+		return BinaryFramer, nil
+	}
+}
+
+type nestFramer struct{}
+
+func (nestFramer) Name() string { return "nest" }
+
+func (nestFramer) WriteFrame(payload []byte) []byte {
+	prefix := strconv.Itoa(len(payload))
+	framed := make([]byte, 0, len(prefix)+1+len(payload))
+	framed = append(framed, prefix...)
+	framed = append(framed, '#')
+	return append(framed, payload...)
+}
+
+func (nestFramer) ReadFrame(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	lengthBuf := make([]byte, 0, 16)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(lengthBuf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == '#' {
+			break
+		}
+		lengthBuf = append(lengthBuf, b)
+		// defensive: avoid runaway length prefix
+		if len(lengthBuf) > 32 {
+			return nil, invalidFrame("Invalid length prefix (too long)")
+		}
+	}
+
+	msgLen, err := strconv.Atoi(string(lengthBuf))
+	if err != nil || msgLen <= 0 {
+		return nil, invalidFrame("Invalid length prefix: %s", lengthBuf)
+	}
+	return readPayload(r, msgLen, maxBytes)
+}
+
+type ndjsonFramer struct{}
+
+func (ndjsonFramer) Name() string { return "ndjson" }
+
+func (ndjsonFramer) WriteFrame(payload []byte) []byte {
+	framed := make([]byte, 0, len(payload)+1)
+	framed = append(framed, payload...)
+	return append(framed, '\n')
+}
+
+func (ndjsonFramer) ReadFrame(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	for {
+		line, err := readLine(r, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		// blank lines are keep-alives at best; skip them
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			return trimmed, nil
+		}
+		putBuffer(line)
+	}
+}
+
+// readLine reads up to and excluding the next '\n' without ever holding more than
+// maxBytes of it; longer lines are skipped and reported as too large
+func readLine(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	line := getBuffer(0)
+	tooLarge := false
+	size := 0
+	for {
+		chunk, err := r.ReadSlice('\n')
+		size += len(chunk)
+		if !tooLarge {
+			if size > maxBytes+1 {
+				tooLarge = true
+				putBuffer(line)
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			putBuffer(line)
+			if err == io.EOF && size > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if tooLarge {
+			return nil, frameTooLarge(size-1, maxBytes)
+		}
+		return line[:len(line)-1], nil
+	}
+}
+
+type binaryFramer struct{}
+
+func (binaryFramer) Name() string { return "binary" }
+
+func (binaryFramer) WriteFrame(payload []byte) []byte {
+	framed := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(framed, uint32(len(payload)))
+	return append(framed, payload...)
+}
+
+func (binaryFramer) ReadFrame(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	msgLen := binary.BigEndian.Uint32(header[:])
+	if msgLen == 0 {
+		return nil, invalidFrame("Invalid length prefix: 0")
+	}
+	return readPayload(r, int(msgLen), maxBytes)
+}
+
+// readPayload reads a length-prefixed payload, skipping it unread when it is over the limit
+func readPayload(r *bufio.Reader, msgLen, maxBytes int) ([]byte, error) {
+	if msgLen > maxBytes {
+		if _, err := r.Discard(msgLen); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return nil, frameTooLarge(msgLen, maxBytes)
+	}
+
+	msgBytes := getBuffer(msgLen)
+	if _, err := io.ReadFull(r, msgBytes); err != nil {
+		putBuffer(msgBytes)
+		return nil, unexpectedEOF(err)
+	}
+	return msgBytes, nil
+}
+
+// unexpectedEOF reports a clean EOF in the middle of a frame as truncation
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
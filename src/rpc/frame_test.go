@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newFrameReader(data []byte) *bufio.Reader {
+	// a small buffer makes frames span several reads
+	return bufio.NewReaderSize(bytes.NewReader(data), 16)
+}
+
+func TestFramerRoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		[]byte(`{"id":"1"}`),
+		[]byte(`{"pattern":"a#b","data":"12#"}`),
+		[]byte("x"),
+		[]byte(`{"data":"` + strings.Repeat("y", pooledBufferSize+10) + `"}`),
+	}
+	for _, framer := range []Framer{NestFramer, NDJSONFramer, BinaryFramer} {
+		t.Run(framer.Name(), func(t *testing.T) {
+			var stream []byte
+			for _, p := range payloads {
+				stream = append(stream, framer.WriteFrame(p)...)
+			}
+			r := newFrameReader(stream)
+			for i, want := range payloads {
+				got, err := framer.ReadFrame(r, 1<<20)
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("frame %d = %.40q, want %.40q", i, got, want)
+				}
+				putBuffer(got)
+			}
+			if _, err := framer.ReadFrame(r, 1<<20); err != io.EOF {
+				t.Fatalf("after the last frame: %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestFramerTruncation(t *testing.T) {
+	payload := []byte(`{"id":"1","pattern":"p"}`)
+	for _, framer := range []Framer{NestFramer, NDJSONFramer, BinaryFramer} {
+		framed := framer.WriteFrame(payload)
+		for i := 1; i < len(framed); i++ {
+			_, err := framer.ReadFrame(newFrameReader(framed[:i]), 1<<20)
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s: reading %d of %d bytes = %v, want io.ErrUnexpectedEOF", framer.Name(), i, len(framed), err)
+			}
+		}
+	}
+}
+
+// TestFramerSkipsOversizedFrames checks that a frame over the limit is reported as recoverable
+// and consumed, so the next frame on the stream is still read correctly
+func TestFramerSkipsOversizedFrames(t *testing.T) {
+	const maxBytes = 32
+	small := []byte(`{"id":"2"}`)
+	for _, framer := range []Framer{NestFramer, NDJSONFramer, BinaryFramer} {
+		for _, size := range []int{maxBytes + 1, 100, 5000} {
+			big := []byte(`{"data":"` + strings.Repeat("z", size-11) + `"}`)
+			stream := append(framer.WriteFrame(big), framer.WriteFrame(small)...)
+			r := newFrameReader(stream)
+
+			_, err := framer.ReadFrame(r, maxBytes)
+			var fe *frameError
+			if !errors.As(err, &fe) || !fe.recoverable || fe.code != CodePayloadTooLarge {
+				t.Fatalf("%s/%d: oversized frame = %v, want a recoverable payload-too-large error", framer.Name(), size, err)
+			}
+			got, err := framer.ReadFrame(r, maxBytes)
+			if err != nil || !bytes.Equal(got, small) {
+				t.Fatalf("%s/%d: next frame = %q, %v", framer.Name(), size, got, err)
+			}
+		}
+
+		exact := []byte(`{"d":"` + strings.Repeat("e", maxBytes-8) + `"}`)
+		got, err := framer.ReadFrame(newFrameReader(framer.WriteFrame(exact)), maxBytes)
+		if err != nil || !bytes.Equal(got, exact) {
+			t.Fatalf("%s: frame of exactly maxBytes = %q, %v", framer.Name(), got, err)
+		}
+	}
+}
+
+func TestFramerRejectsInvalidPrefixes(t *testing.T) {
+	tests := []struct {
+		name   string
+		framer Framer
+		data   string
+	}{
+		{"nest empty length", NestFramer, "#{}"},
+		{"nest zero length", NestFramer, "0#"},
+		{"nest negative length", NestFramer, "-5#abcde"},
+		{"nest non-numeric length", NestFramer, "1a#{}"},
+		{"nest runaway length", NestFramer, strings.Repeat("9", 40) + "#"},
+		{"nest overflowing length", NestFramer, strings.Repeat("9", 30) + "#"},
+		{"binary zero length", BinaryFramer, "\x00\x00\x00\x00{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.framer.ReadFrame(newFrameReader([]byte(tt.data)), 1<<20)
+			var fe *frameError
+			if !errors.As(err, &fe) || fe.recoverable {
+				t.Fatalf("ReadFrame = %v, want a fatal frame error", err)
+			}
+		})
+	}
+}
+
+func TestNDJSONFramerSkipsBlankLines(t *testing.T) {
+	r := newFrameReader([]byte("\n  \r\n\t\n  {\"id\":\"1\"}  \r\n\n{\"id\":\"2\"}\n"))
+	for _, want := range []string{`{"id":"1"}`, `{"id":"2"}`} {
+		got, err := NDJSONFramer.ReadFrame(r, 1<<20)
+		if err != nil || string(got) != want {
+			t.Fatalf("ReadFrame = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := NDJSONFramer.ReadFrame(r, 1<<20); err != io.EOF {
+		t.Fatalf("after the last line: %v, want io.EOF", err)
+	}
+}
+
+func TestDetectFramer(t *testing.T) {
+	tests := []struct {
+		first string
+		want  Framer
+	}{
+		{"12#{}", NestFramer},
+		{"0", NestFramer},
+		{"9", NestFramer},
+		{"{}\n", NDJSONFramer},
+		{" {}\n", NDJSONFramer},
+		{"\t", NDJSONFramer},
+		{"\r\n", NDJSONFramer},
+		{"\n", NDJSONFramer},
+		{"\x00\x00\x00\x02{}", BinaryFramer},
+		{"\x81", BinaryFramer},
+		{"a", BinaryFramer},
+	}
+	for _, tt := range tests {
+		r := newFrameReader([]byte(tt.first))
+		got, err := detectFramer(r)
+		if err != nil {
+			t.Fatalf("detectFramer(%q): %v", tt.first, err)
+		}
+		if got != tt.want {
+			t.Fatalf("detectFramer(%q) = %s, want %s", tt.first, got.Name(), tt.want.Name())
+		}
+		if r.Buffered() != len(tt.first) {
+			t.Fatalf("detectFramer(%q) consumed input", tt.first)
+		}
+	}
+
+	if _, err := detectFramer(newFrameReader(nil)); err != io.EOF {
+		t.Fatalf("detectFramer on an empty stream = %v, want io.EOF", err)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	tests := []struct {
+		n      int
+		pooled bool
+	}{
+		{0, true},
+		{10, true},
+		{pooledBufferSize, true},
+		{pooledBufferSize + 1, false},
+	}
+	for _, tt := range tests {
+		b := getBuffer(tt.n)
+		if len(b) != tt.n {
+			t.Fatalf("getBuffer(%d) has length %d", tt.n, len(b))
+		}
+		if pooled := cap(b) == pooledBufferSize; pooled != tt.pooled {
+			t.Fatalf("getBuffer(%d) has capacity %d", tt.n, cap(b))
+		}
+		putBuffer(b)
+	}
+
+	// buffers that did not come from the pool, or were trimmed at the front, are dropped
+	putBuffer(make([]byte, 10))
+	putBuffer(make([]byte, 0, 2*pooledBufferSize))
+	putBuffer(getBuffer(10)[1:])
+	putBuffer(nil)
+	for i := 0; i < 4; i++ {
+		if b := getBuffer(1); cap(b) != pooledBufferSize {
+			t.Fatalf("pool handed out a buffer with capacity %d", cap(b))
+		}
+	}
+}
@@ -1,20 +1,26 @@
 package rpc
 
 import (
-	"context"
-	"fmt"
+	"bufio"
+	"errors"
 	"io"
-	"net"
-	"strconv"
 	"time"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
 )
 
-func (s *Server) handleConnection(conn net.Conn) {
+func (s *Server) handleConnection(c *connection) {
+	conn := c.conn
+	ctx := c.ctx
 	defer func() {
+		if c.draining.Load() {
+			// Shutdown is draining: let handlers finish; it cancels them at its deadline
+			c.inflight.Wait()
+		}
+		c.cancel()
+		// dispatched work stops waiting on its handlers once their context is cancelled
+		c.inflight.Wait()
+		c.closeWriter()
 		s.connMu.Lock()
-		delete(s.activeConns, conn)
+		delete(s.activeConns, c)
 		s.metrics.mu.Lock()
 		s.metrics.ActiveConns--
 		s.metrics.mu.Unlock()
@@ -23,13 +29,17 @@ func (s *Server) handleConnection(conn net.Conn) {
 		s.wg.Done()
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	go c.writeLoop()
+
+	if !s.handshake(c) {
+		return
+	}
 
-	heartbeatTimer := time.NewTimer(s.config.HeartbeatTimeout)
-	defer heartbeatTimer.Stop()
+	// Evict peers that send nothing (not even a ping) within HeartbeatTimeout
+	c.idle = time.AfterFunc(s.config.HeartbeatTimeout, func() { s.evictIdle(c) })
+	defer c.idle.Stop()
 
-	// Handle heartbeats (unchanged)
+	// Handle heartbeats
 	go func() {
 		ticker := time.NewTicker(s.config.HeartbeatInterval)
 		defer ticker.Stop()
@@ -38,99 +48,71 @@ func (s *Server) handleConnection(conn net.Conn) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				s.sendHeartbeat(conn)
+				s.sendHeartbeat(c)
 			}
 		}
 	}()
 
-	// Main read loop using length-prefixed framing: "<len>#<json bytes>"
-	for {
-		// 1) Read length prefix until '#'
-		lengthBuf := make([]byte, 0, 16)
-		tmp := make([]byte, 1)
-		for {
-			n, err := conn.Read(tmp)
-			if err != nil {
-				// Connection closed or read error — treat like scanner end
-				if err == io.EOF {
-					// graceful close by peer
-					return
-				}
-				// Log & increment error metrics then return
-				s.metrics.mu.Lock()
-				s.metrics.ErrorsTotal++
-				s.metrics.mu.Unlock()
-				utility.LogAndPrint(fmt.Sprintf("RPC: Read error (prefix) | RemoteAddr: %s | Error: %v",
-					conn.RemoteAddr().String(), err))
-				return
-			}
-			if n == 0 {
-				continue
-			}
-			if tmp[0] == '#' {
-				break
-			}
-			lengthBuf = append(lengthBuf, tmp[0])
-			// defensive: avoid runaway length prefix
-			if len(lengthBuf) > 32 {
-				s.metrics.mu.Lock()
-				s.metrics.ErrorsTotal++
-				s.metrics.mu.Unlock()
-				s.sendError(conn, "unknown", "Invalid length prefix (too long)")
-				return
+	if c.draining.Load() {
+		return
+	}
+	reader := bufio.NewReaderSize(conn, s.config.ReadBufferSize)
+	framer := s.config.Framer
+	if framer == nil {
+		detected, err := detectFramer(reader)
+		if err != nil {
+			if err != io.EOF && !isClosedError(err) && !c.draining.Load() {
+				s.logger.Warn("Read error (framing detection)", c.logAttrs("error", err)...)
 			}
+			return
 		}
+		framer = detected
+	}
+	c.setFramer(framer)
 
-		msgLenStr := string(lengthBuf)
-		msgLen, err := strconv.Atoi(msgLenStr)
-		if err != nil || msgLen <= 0 {
+	// Main read loop; the framer splits the stream into payloads
+	for {
+		if c.draining.Load() {
+			return
+		}
+		msgBytes, err := framer.ReadFrame(reader, s.config.MaxMessageBytes)
+		if err != nil {
+			// graceful close by peer, or the read deadline set by stopReading
+			if err == io.EOF || c.draining.Load() {
+				return
+			}
 			s.metrics.mu.Lock()
 			s.metrics.ErrorsTotal++
 			s.metrics.mu.Unlock()
-			s.sendError(conn, "unknown", fmt.Sprintf("Invalid length prefix: %s", msgLenStr))
-			// continue to next message (or connection likely unusable)
-			continue
-		}
 
-		// 2) Read exactly msgLen bytes
-		msgBytes := make([]byte, msgLen)
-		totalRead := 0
-		for totalRead < msgLen {
-			n, err := conn.Read(msgBytes[totalRead:])
-			if err != nil {
-				if err == io.EOF {
-					s.metrics.mu.Lock()
-					s.metrics.ErrorsTotal++
-					s.metrics.mu.Unlock()
-					utility.LogAndPrint(fmt.Sprintf("RPC: Unexpected EOF while reading message body | RemoteAddr: %s",
-						conn.RemoteAddr().String()))
-					return
-				}
+			var frameErr *frameError
+			switch {
+			case errors.As(err, &frameErr) && frameErr.recoverable:
+				// the payload was skipped, so the stream is still in sync
 				s.metrics.mu.Lock()
-				s.metrics.ErrorsTotal++
+				s.metrics.OversizedFrames++
 				s.metrics.mu.Unlock()
-				s.sendError(conn, "unknown", fmt.Sprintf("Read error: %v", err))
-				return
-			}
-			if n == 0 {
+				s.logger.Warn("Rejected frame", c.logAttrs("error", frameErr)...)
+				s.sendError(c, "unknown", "", NewError(frameErr.code, frameErr.Error()))
 				continue
-			}
-			totalRead += n
-		}
-
-		// Reset heartbeat timer on any received message (similar effect to scanner.Scan())
-		if !heartbeatTimer.Stop() {
-			select {
-			case <-heartbeatTimer.C:
+			case errors.As(err, &frameErr):
+				// the stream cannot be resynchronised after a bad frame
+				s.sendError(c, "unknown", "", NewError(frameErr.code, frameErr.Error()))
+			case err == io.ErrUnexpectedEOF:
+				s.logger.Warn("Unexpected EOF while reading message body", c.logAttrs()...)
 			default:
+				s.logger.Warn("Read error", c.logAttrs("error", err)...)
 			}
+			return
 		}
-		heartbeatTimer.Reset(s.config.HeartbeatTimeout)
+
+		// Any received frame proves the peer is alive
+		c.idle.Reset(s.config.HeartbeatTimeout)
 
 		// Check if server is shutting down
 		select {
 		case <-ctx.Done():
-			utility.LogAndPrint(fmt.Sprintf("RPC: Connection closed | RemoteAddr: %s", conn.RemoteAddr().String()))
+			s.logger.Debug("Connection closed", c.logAttrs()...)
 			return
 		default:
 			// parse request (same as before)
@@ -138,12 +120,26 @@ func (s *Server) handleConnection(conn net.Conn) {
 				continue
 			}
 
-			req, err := parseRequest(msgBytes)
+			codec := c.negotiateCodec(msgBytes)
+			req, err := parseRequest(codec, msgBytes)
+			var reqID string
+			if err != nil {
+				reqID = extractRequestID(codec, msgBytes)
+			}
+			// the request holds its own copies from here on
+			putBuffer(msgBytes)
 			if err != nil {
 				s.metrics.mu.Lock()
 				s.metrics.ErrorsTotal++
 				s.metrics.mu.Unlock()
-				s.sendError(conn, "unknown", fmt.Sprintf("Invalid JSON: %v", err))
+				s.sendError(c, "unknown", reqID, Errorf(CodeInvalidArgument, "Invalid %s payload: %v", codec.Name(), err))
+				continue
+			}
+
+			if req.Cancel {
+				if req.ID != "" && c.cancelCall(req.ID) {
+					s.logger.Debug("Request cancelled by client", c.logAttrs("request_id", req.ID)...)
+				}
 				continue
 			}
 
@@ -152,7 +148,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 				s.metrics.mu.Lock()
 				s.metrics.HeartbeatsTotal++
 				s.metrics.mu.Unlock()
-				s.sendResponse(conn, "ping", Response{Response: "pong", Id: req.ID})
+				s.sendResponse(c, "ping", Response{Response: "pong", Id: req.ID})
 				continue
 			}
 
@@ -161,58 +157,26 @@ func (s *Server) handleConnection(conn net.Conn) {
 				s.metrics.mu.Lock()
 				s.metrics.ErrorsTotal++
 				s.metrics.mu.Unlock()
-				s.sendError(conn, "unknown", "Empty pattern command")
+				s.sendError(c, "unknown", req.ID, InvalidArgument("Empty pattern command"))
 				continue
 			}
 
-			s.metrics.mu.Lock()
-			s.metrics.RequestsTotal++
-			s.metrics.mu.Unlock()
-
-			utility.LogAndPrint(fmt.Sprintf("RPC: Received request | Pattern: %s | RemoteAddr: %s | Time: %s",
-				req.Pattern.Cmd, conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05")))
-
-			// Retry logic for handler execution (unchanged)
-			var result interface{}
-			var handlerErr error
-			for attempt := 0; attempt <= s.config.RetryAttempts; attempt++ {
-				if handler, ok := s.registry.Get(req.Pattern.Cmd); ok {
-					startTime := time.Now()
-					result, handlerErr = handler(req.Data)
-					if handlerErr == nil {
-						s.metrics.mu.Lock()
-						s.metrics.ProcessingTime += time.Since(startTime)
-						s.metrics.mu.Unlock()
-						break
-					}
-					if attempt < s.config.RetryAttempts {
-						utility.LogAndPrint(fmt.Sprintf("RPC: Handler retry | Pattern: %s | Attempt: %d | Error: %v",
-							req.Pattern.Cmd, attempt+1, handlerErr))
-						time.Sleep(s.config.RetryDelay)
-					}
-				} else {
-					s.metrics.mu.Lock()
-					s.metrics.ErrorsTotal++
-					s.metrics.mu.Unlock()
-					s.sendError(conn, req.Pattern.Cmd, "Unknown pattern")
-					// continue to next incoming message
-					goto NEXT_MESSAGE
-				}
-			}
-
-			if handlerErr != nil {
-				s.metrics.mu.Lock()
-				s.metrics.ErrorsTotal++
-				s.metrics.mu.Unlock()
-				s.sendError(conn, req.Pattern.Cmd, handlerErr.Error())
+			if !s.admit(c, req) {
 				continue
 			}
 
-			s.sendResponse(conn, req.Pattern.Cmd, Response{Response: result, Id: req.ID, Status: "ok", IsDisposed: false})
+			// Requests are processed concurrently and answered out of order, correlated by id.
+			// Events carry no id and never get a reply.
+			var dispatched bool
+			if req.ID == "" {
+				dispatched = c.dispatch(func(sl *slot) { s.handleEvent(c, req, sl) })
+			} else {
+				dispatched = c.dispatch(func(sl *slot) { s.handleRequest(c, req, sl) })
+			}
+			if !dispatched {
+				return
+			}
 		}
-	NEXT_MESSAGE:
-		// continue to next loop iteration
-		continue
 	}
 
 }
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ServerState is a stage of the server's lifecycle. It only moves forward:
+// new → running → draining → stopped. Shutdown on a server that never started goes
+// straight to draining.
+type ServerState int32
+
+const (
+	// StateNew is a server that has not started serving yet
+	StateNew ServerState = iota
+	// StateRunning is a server accepting connections
+	StateRunning
+	// StateDraining is a server inside Shutdown, finishing in-flight requests
+	StateDraining
+	// StateStopped is a server whose connections and goroutines have all exited
+	StateStopped
+)
+
+func (st ServerState) String() string {
+	switch st {
+	case StateNew:
+		return "new"
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("ServerState(%d)", int32(st))
+}
+
+func (st ServerState) MarshalText() ([]byte, error) { return []byte(st.String()), nil }
+
+// ErrServerStarted is returned by Start when the server is already running
+var ErrServerStarted = errors.New("rpc: server already started")
+
+// State returns the server's current lifecycle state
+func (s *Server) State() ServerState {
+	return ServerState(s.state.Load())
+}
+
+// Done returns a channel that is closed once the server has stopped
+func (s *Server) Done() <-chan struct{} {
+	return s.shutdownChan
+}
+
+// Wait blocks until the server has stopped and returns the error of the Shutdown that stopped it
+func (s *Server) Wait() error {
+	<-s.shutdownChan
+	return s.shutdownErr
+}
+
+// startServing moves the server to running for a new listener; Start requires a new server,
+// Serve may also add listeners to a running one. It must be called with s.mu held.
+func (s *Server) startServing(allowRunning bool) error {
+	switch st := s.State(); {
+	case st == StateNew:
+		s.state.Store(int32(StateRunning))
+		return nil
+	case st == StateRunning && allowRunning:
+		return nil
+	case st == StateRunning:
+		return ErrServerStarted
+	default:
+		return ErrServerClosed
+	}
+}
+
+// stop marks the server stopped once every connection has exited
+func (s *Server) stop(err error) {
+	s.shutdownErr = err
+	s.state.Store(int32(StateStopped))
+	close(s.shutdownChan)
+}
@@ -0,0 +1,399 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger})
+	s.RegisterHandler("echo", func(data json.RawMessage) (interface{}, error) {
+		return data, nil
+	})
+	return s
+}
+
+// serve runs s on a fresh loopback listener and returns its address and Serve's result
+func serve(t *testing.T, s *Server) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	waitForState(t, s, StateRunning)
+	return l.Addr().String(), served
+}
+
+func waitForState(t *testing.T, s *Server, want ServerState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %s, want %s", s.State(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+	client, err := Dial(context.Background(), &ClientConfig{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func shutdown(t *testing.T, s *Server) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestLifecycleTransitions(t *testing.T) {
+	s := newTestServer(t)
+	if s.State() != StateNew {
+		t.Fatalf("state = %s, want new", s.State())
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if s.State() != StateRunning {
+		t.Fatalf("state = %s, want running", s.State())
+	}
+	if err := s.Start(); !errors.Is(err, ErrServerStarted) {
+		t.Fatalf("second Start = %v, want ErrServerStarted", err)
+	}
+
+	shutdown(t, s)
+	if s.State() != StateStopped {
+		t.Fatalf("state = %s, want stopped", s.State())
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Fatal("Done not closed after Shutdown")
+	}
+
+	if err := s.Start(); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Start after Shutdown = %v, want ErrServerClosed", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve after Shutdown = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServeSeveralListeners(t *testing.T) {
+	s := newTestServer(t)
+	addr1, served1 := serve(t, s)
+	addr2, served2 := serve(t, s)
+
+	for _, addr := range []string{addr1, addr2} {
+		if _, err := dial(t, addr).Send(context.Background(), "echo", "hi"); err != nil {
+			t.Fatalf("echo via %s: %v", addr, err)
+		}
+	}
+
+	shutdown(t, s)
+	for _, served := range []<-chan error{served1, served2} {
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Serve = %v, want ErrServerClosed", err)
+		}
+	}
+}
+
+func TestShutdownBeforeStart(t *testing.T) {
+	s := newTestServer(t)
+	shutdown(t, s)
+	if s.State() != StateStopped {
+		t.Fatalf("state = %s, want stopped", s.State())
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+}
+
+func TestShutdownIsIdempotent(t *testing.T) {
+	s := newTestServer(t)
+	addr, _ := serve(t, s)
+	client := dial(t, addr)
+	if _, err := client.Send(context.Background(), "echo", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Shutdown(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Shutdown = %v", err)
+		}
+	}
+
+	shutdown(t, s)
+	if s.State() != StateStopped {
+		t.Fatalf("state = %s, want stopped", s.State())
+	}
+}
+
+func TestWaitReturnsShutdownError(t *testing.T) {
+	s := newTestServer(t)
+	started := make(chan struct{})
+	s.RegisterContextHandler("block", func(ctx *Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	addr, _ := serve(t, s)
+	client := dial(t, addr)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := client.Send(context.Background(), "block", nil)
+		sent <- err
+	}()
+	<-started
+
+	waited := make(chan error, 1)
+	go func() { waited <- s.Wait() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	if err := <-waited; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want DeadlineExceeded", err)
+	}
+	if s.State() != StateStopped {
+		t.Fatalf("state = %s, want stopped", s.State())
+	}
+	if err := <-sent; err == nil {
+		t.Fatal("blocked request succeeded after a forced shutdown")
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	s := newTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.RegisterHandler("slow", func(json.RawMessage) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	addr, _ := serve(t, s)
+	client := dial(t, addr)
+
+	type result struct {
+		out json.RawMessage
+		err error
+	}
+	sent := make(chan result, 1)
+	go func() {
+		out, err := client.Send(context.Background(), "slow", nil)
+		sent <- result{out, err}
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+	waitForState(t, s, StateDraining)
+	if !s.GetMetrics().Draining {
+		t.Fatal("metrics do not report draining")
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Fatal("new connection accepted while draining")
+	}
+
+	close(release)
+	res := <-sent
+	if res.err != nil || string(res.out) != `"done"` {
+		t.Fatalf("in-flight request = %s, %v", res.out, res.err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if s.GetMetrics().Draining {
+		t.Fatal("metrics still report draining")
+	}
+}
+
+func TestConcurrentStartServeShutdown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s := newTestServer(t)
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if err := s.Start(); err != nil && !errors.Is(err, ErrServerStarted) && !errors.Is(err, ErrServerClosed) {
+				t.Errorf("Start = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+				t.Errorf("Serve = %v", err)
+			}
+			l.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(context.Background()); err != nil {
+				t.Errorf("Shutdown = %v", err)
+			}
+		}()
+		wg.Wait()
+
+		select {
+		case <-s.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("server did not stop")
+		}
+	}
+}
+
+func TestRequestsDuringShutdown(t *testing.T) {
+	s := newTestServer(t)
+	addr, _ := serve(t, s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client := dial(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := client.Send(context.Background(), "echo", "x"); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	shutdown(t, s)
+	wg.Wait()
+	if s.GetMetrics().ActiveConns != 0 {
+		t.Fatalf("ActiveConns = %d after shutdown", s.GetMetrics().ActiveConns)
+	}
+}
+
+func TestServeReturnsWhenListenerFails(t *testing.T) {
+	s := newTestServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	waitForState(t, s, StateRunning)
+
+	l.Close()
+	select {
+	case err := <-served:
+		if err == nil || errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Serve = %v, want the accept error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after its listener was closed")
+	}
+	shutdown(t, s)
+}
+
+func TestShutdownForceClosesHungEventHandler(t *testing.T) {
+	s := newTestServer(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s.RegisterEventHandler("hang", func(json.RawMessage) error {
+		close(started)
+		<-release
+		return nil
+	})
+	addr, _ := serve(t, s)
+	client := dial(t, addr)
+	if err := client.Emit(context.Background(), "hang", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("server still %s with %d connections after a forced shutdown", s.State(), s.GetMetrics().ActiveConns)
+	}
+	if s.GetMetrics().ActiveConns != 0 {
+		t.Fatalf("ActiveConns = %d after shutdown", s.GetMetrics().ActiveConns)
+	}
+}
+
+func TestSlowDrainNotifierDoesNotHoldUpOtherConnections(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, DrainNotifier: func(*DrainingConn) {
+		// the first connection stands in for a slow consumer
+		if calls.Add(1) == 1 {
+			<-release
+		}
+	}})
+	s.RegisterHandler("echo", func(data json.RawMessage) (interface{}, error) { return data, nil })
+	addr, _ := serve(t, s)
+	for i := 0; i < 2; i++ {
+		if _, err := dial(t, addr).Send(context.Background(), "echo", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.GetMetrics().ActiveConns != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ActiveConns = %d while one notifier is blocked, want 1", s.GetMetrics().ActiveConns)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+}
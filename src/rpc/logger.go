@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Logger receives the server's log events. Messages are constant strings; the variable parts
+// come as alternating key/value pairs (pattern, conn_id, request_id, duration, error, ...).
+// *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NopLogger discards everything
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// defaultLogger is slog's default logger, tagged so the server's lines can be told apart
+func defaultLogger() Logger {
+	return slog.Default().With("component", "rpc")
+}
+
+// LogSampling caps how often one message is logged: within each Interval the first Initial
+// occurrences are logged, then only every Thereafter-th. Errors are never sampled.
+type LogSampling struct {
+	Initial    int
+	Thereafter int
+	Interval   time.Duration
+}
+
+type sampleCounter struct {
+	windowStart time.Time
+	count       int
+}
+
+type sampledLogger struct {
+	next     Logger
+	sampling LogSampling
+	mu       sync.Mutex
+	counters map[string]*sampleCounter
+}
+
+// NewSampledLogger wraps next so that high-frequency messages are sampled
+func NewSampledLogger(next Logger, sampling LogSampling) Logger {
+	if sampling.Initial <= 0 {
+		sampling.Initial = 100
+	}
+	if sampling.Interval <= 0 {
+		sampling.Interval = time.Second
+	}
+	return &sampledLogger{next: next, sampling: sampling, counters: make(map[string]*sampleCounter)}
+}
+
+func (l *sampledLogger) Debug(msg string, args ...any) {
+	if l.sample("debug", msg) {
+		l.next.Debug(msg, args...)
+	}
+}
+
+func (l *sampledLogger) Info(msg string, args ...any) {
+	if l.sample("info", msg) {
+		l.next.Info(msg, args...)
+	}
+}
+
+func (l *sampledLogger) Warn(msg string, args ...any) {
+	if l.sample("warn", msg) {
+		l.next.Warn(msg, args...)
+	}
+}
+
+func (l *sampledLogger) Error(msg string, args ...any) {
+	l.next.Error(msg, args...)
+}
+
+func (l *sampledLogger) sample(level, msg string) bool {
+	key := level + "|" + msg
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counter, ok := l.counters[key]
+	if !ok || now.Sub(counter.windowStart) >= l.sampling.Interval {
+		if !ok {
+			counter = &sampleCounter{}
+			l.counters[key] = counter
+		}
+		counter.windowStart = now
+		counter.count = 0
+	}
+	counter.count++
+
+	if counter.count <= l.sampling.Initial {
+		return true
+	}
+	return l.sampling.Thereafter > 0 && (counter.count-l.sampling.Initial)%l.sampling.Thereafter == 0
+}
+
+// logAttrs prefixes args with the fields identifying a connection
+func (c *connection) logAttrs(args ...any) []any {
+	return append([]any{"conn_id", c.id, "remote_addr", c.conn.RemoteAddr().String()}, args...)
+}
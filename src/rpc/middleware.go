@@ -0,0 +1,48 @@
+package rpc
+
+// Middleware wraps a handler with cross-cutting logic, like a NestJS interceptor.
+// It may short-circuit by not calling next, rewrite ctx.Request.Data before calling it,
+// or transform the result it returns. With a binary codec Data starts out nil; read the
+// request data with ctx.Bind.
+type Middleware func(next ContextHandler) ContextHandler
+
+// Use adds middleware applied to every pattern, outermost first
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// WithMiddleware adds middleware for one pattern; it runs inside the server-wide middleware
+func WithMiddleware(middleware ...Middleware) PatternOption {
+	return func(r *route) {
+		r.middleware = append(r.middleware, middleware...)
+	}
+}
+
+// chainMiddleware wraps handler so that middleware[0] is the outermost call
+func chainMiddleware(handler ContextHandler, middleware ...Middleware) ContextHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// buildHandler composes the full call chain for a route
+func (s *Server) buildHandler(rt *route) ContextHandler {
+	s.mu.Lock()
+	global := append([]Middleware(nil), s.middleware...)
+	s.mu.Unlock()
+
+	handler := rt.handler
+	// a stream that already emitted values cannot be replayed
+	if !rt.stream {
+		policy := rt.retry
+		if policy == nil {
+			policy = s.config.RetryPolicy
+		}
+		handler = s.withRetry(handler, policy)
+	}
+	handler = chainMiddleware(handler, rt.middleware...)
+	return chainMiddleware(handler, global...)
+}
@@ -1,45 +1,100 @@
 package rpc
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
 type Config struct {
-	Addr              string
-	Timeout           time.Duration
-	MaxConnections    int
-	RateLimitPerSec   int
-	RateLimitBurst    int
-	RetryAttempts     int
-	RetryDelay        time.Duration
-	HeartbeatInterval time.Duration
-	HeartbeatTimeout  time.Duration
+	Network               string
+	Addr                  string
+	TLS                   *TLSConfig
+	Timeout               time.Duration
+	MaxConnections        int
+	MaxInFlightPerConn    int
+	WriteTimeout          time.Duration
+	WriteQueueSize        int
+	Codec                 Codec
+	Codecs                []Codec
+	Framer                Framer
+	MaxMessageBytes       int
+	ReadBufferSize        int
+	RateLimitPerSec       int
+	RateLimitBurst        int
+	GlobalRateLimitPerSec int
+	GlobalRateLimitBurst  int
+	IPRateLimitPerSec     int
+	IPRateLimitBurst      int
+	RetryAttempts         int
+	RetryDelay            time.Duration
+	RetryPolicy           *RetryPolicy
+	HeartbeatInterval     time.Duration
+	HeartbeatTimeout      time.Duration
+	HeartbeatPayload      interface{}
+	PanicHandler          PanicHandler
+	ErrorMapper           ErrorMapper
+	DrainNotifier         DrainNotifier
+	MetricsAddr           string
+	LatencyBuckets        []float64
+	SpanExporter          SpanExporter
+	Logger                Logger
+	LogSampling           *LogSampling
 }
 
 type Server struct {
-	registry       *Registry
-	config         *Config
-	mu             sync.Mutex
-	listener       net.Listener
-	wg             sync.WaitGroup
-	activeConns    map[net.Conn]struct{}
-	shutdownChan   chan struct{}
-	connMu         sync.RWMutex
-	limiter        *rate.Limiter
-	metrics        *Metrics
-	isShuttingDown bool
+	registry      *Registry
+	config        *Config
+	mu            sync.Mutex
+	listeners     []net.Listener
+	wg            sync.WaitGroup
+	activeConns   map[*connection]struct{}
+	nextConnID    atomic.Uint64
+	ctx           context.Context
+	cancel        context.CancelFunc
+	shutdownChan  chan struct{}
+	connMu        sync.RWMutex
+	middleware    []Middleware
+	limiter       *rate.Limiter
+	ipLimiters    *ipLimiters
+	metrics       *Metrics
+	stats         *statsRegistry
+	metricsServer *http.Server
+	metricsOnce   sync.Once
+	metricsErr    error
+	tlsConfig     *tls.Config
+	tlsOnce       sync.Once
+	tlsErr        error
+	logger        Logger
+	state         atomic.Int32
+	shutdownErr   error
 }
 
 type Metrics struct {
-	RequestsTotal   uint64
-	ErrorsTotal     uint64
-	ActiveConns     uint64
-	ProcessingTime  time.Duration
-	HeartbeatsTotal uint64
-	HeartbeatFails  uint64
-	mu              sync.Mutex
+	RequestsTotal      uint64
+	EventsTotal        uint64
+	ErrorsTotal        uint64
+	TimeoutsTotal      uint64
+	PanicsTotal        uint64
+	ActiveConns        uint64
+	ProcessingTime     time.Duration
+	HeartbeatsTotal    uint64
+	HeartbeatFails     uint64
+	IdleEvictions      uint64
+	OversizedFrames    uint64
+	WritesDropped      uint64
+	WritesBlocked      uint64
+	SlowConsumers      uint64
+	TLSHandshakeErrors uint64
+	RateLimitedTotal   uint64
+	Draining           bool
+	CircuitBreakers    map[string]BreakerStats
+	Patterns           map[string]PatternStats
+	mu                 sync.Mutex
 }
@@ -0,0 +1,194 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackCodec implements MessagePack (https://msgpack.org) on top of vmihailenco/msgpack
+type msgpackCodec struct{}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func init() {
+	msgpack.Register(json.RawMessage(nil), msgpackEncodeJSON, msgpackDecodeJSON)
+	msgpack.Register((*Error)(nil), msgpackEncodeJSON, nil)
+}
+
+func (msgpackCodec) Name() string { return "MessagePack" }
+
+// Match recognises a msgpack map header; every envelope is a map
+func (msgpackCodec) Match(payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	b := payload[0]
+	return b&0xf0 == 0x80 || b == 0xde || b == 0xdf
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := checkMsgpack(data); err != nil {
+		return err
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (c msgpackCodec) decodeEnvelope(payload []byte) (*envelope, error) {
+	var raw struct {
+		ID       msgpack.RawMessage `json:"id"`
+		Pattern  msgpack.RawMessage `json:"pattern"`
+		Data     msgpack.RawMessage `json:"data"`
+		Metadata msgpack.RawMessage `json:"metadata"`
+		Cancel   bool               `json:"cancel"`
+	}
+	if err := c.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	return &envelope{ID: raw.ID, Pattern: raw.Pattern, Data: raw.Data, Metadata: raw.Metadata, Cancel: raw.Cancel}, nil
+}
+
+// msgpackEncodeJSON encodes a json.Marshaler as the msgpack form of its JSON
+func msgpackEncodeJSON(enc *msgpack.Encoder, v reflect.Value) error {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return enc.EncodeNil()
+	}
+	data, err := v.Interface().(json.Marshaler).MarshalJSON()
+	if err != nil {
+		return err
+	}
+	value, err := decodeJSONValue(data)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(value)
+}
+
+// msgpackDecodeJSON decodes a msgpack value into a json.RawMessage
+func msgpackDecodeJSON(dec *msgpack.Decoder, v reflect.Value) error {
+	value, err := dec.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	v.SetBytes(data)
+	return nil
+}
+
+// checkMsgpack walks a payload before the library decodes it, since the library allocates
+// whatever length a header claims and recurses without bound. It rejects nesting deeper than
+// maxDecodeDepth, lengths running past the end of the payload, and trailing bytes.
+func checkMsgpack(data []byte) error {
+	pos := 0
+	readLen := func(size int) (int, error) {
+		if len(data)-pos < size {
+			return 0, errMsgpackShort
+		}
+		var n uint64
+		for _, b := range data[pos : pos+size] {
+			n = n<<8 | uint64(b)
+		}
+		pos += size
+		if n > uint64(len(data)-pos) {
+			return 0, errMsgpackShort
+		}
+		return int(n), nil
+	}
+
+	// items still to read at each open level
+	pending := []int{1}
+	for len(pending) > 0 {
+		if pending[len(pending)-1] == 0 {
+			pending = pending[:len(pending)-1]
+			continue
+		}
+		pending[len(pending)-1]--
+		if pos >= len(data) {
+			return errMsgpackShort
+		}
+		b := data[pos]
+		pos++
+
+		var skip, items int
+		var err error
+		switch {
+		case b <= 0x7f || b >= 0xe0 || b == 0xc0 || b == 0xc2 || b == 0xc3:
+		case b&0xe0 == 0xa0:
+			skip = int(b & 0x1f)
+		case b&0xf0 == 0x90:
+			items = int(b & 0x0f)
+		case b&0xf0 == 0x80:
+			items = 2 * int(b&0x0f)
+		case b == 0xc4 || b == 0xd9:
+			skip, err = readLen(1)
+		case b == 0xc5 || b == 0xda:
+			skip, err = readLen(2)
+		case b == 0xc6 || b == 0xdb:
+			skip, err = readLen(4)
+		case b == 0xc7, b == 0xc8, b == 0xc9:
+			// ext: length, then a type byte
+			skip, err = readLen(1 << (b - 0xc7))
+			skip++
+		case b == 0xca:
+			skip = 4
+		case b == 0xcb:
+			skip = 8
+		case b >= 0xcc && b <= 0xcf:
+			skip = 1 << (b - 0xcc)
+		case b >= 0xd0 && b <= 0xd3:
+			skip = 1 << (b - 0xd0)
+		case b >= 0xd4 && b <= 0xd8:
+			// fixext: a type byte and 1 to 16 bytes of data
+			skip = 1 + 1<<(b-0xd4)
+		case b == 0xdc:
+			items, err = readLen(2)
+		case b == 0xdd:
+			items, err = readLen(4)
+		case b == 0xde:
+			items, err = readLen(2)
+			items *= 2
+		case b == 0xdf:
+			items, err = readLen(4)
+			items *= 2
+		default:
+			return fmt.Errorf("msgpack: invalid code %#x", b)
+		}
+		if err != nil {
+			return err
+		}
+		if skip > len(data)-pos {
+			return errMsgpackShort
+		}
+		pos += skip
+		if items > 0 {
+			if len(pending) > maxDecodeDepth {
+				return errMaxDepth
+			}
+			pending = append(pending, items)
+		}
+	}
+	if pos != len(data) {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(data)-pos)
+	}
+	return nil
+}
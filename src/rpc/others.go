@@ -1,13 +1,8 @@
 package rpc
 
 import (
-	"encoding/json"
-	"fmt"
-	"net"
 	"strings"
 	"time"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
 )
 
 func (s *Server) StartHeartbeat() {
@@ -18,8 +13,8 @@ func (s *Server) StartHeartbeat() {
 		select {
 		case <-ticker.C:
 			s.connMu.RLock()
-			for conn := range s.activeConns {
-				go s.sendHeartbeat(conn) // send asynchronously per connection
+			for c := range s.activeConns {
+				go s.sendHeartbeat(c) // send asynchronously per connection
 			}
 			s.connMu.RUnlock()
 		case <-s.shutdownChan:
@@ -28,30 +23,47 @@ func (s *Server) StartHeartbeat() {
 	}
 }
 
-func (s *Server) sendHeartbeat(conn net.Conn) {
+func (s *Server) sendHeartbeat(c *connection) {
 	defer func() {
 		if r := recover(); r != nil {
-			utility.LogAndPrint(fmt.Sprintf("RPC: Panic in sendHeartbeat | RemoteAddr: %s | Recovered: %v", conn.RemoteAddr().String(), r))
+			s.logger.Error("Panic in sendHeartbeat", c.logAttrs("recovered", r)...)
 		}
 	}()
 
-	resp := Response{Response: "ping", Id: "heartbeat", IsDisposed: true}
-	jsonBytes, err := json.Marshal(resp)
+	var payload interface{} = Response{Response: "ping", Id: "heartbeat", IsDisposed: true}
+	if s.config.HeartbeatPayload != nil {
+		payload = s.config.HeartbeatPayload
+	}
+	frame, err := c.encode(payload)
+	if err == errFramingUnknown {
+		// nothing to send until we know how the peer frames messages
+		return
+	}
 	if err != nil {
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to marshal heartbeat | Error: %v", err))
+		s.logger.Error("Failed to marshal heartbeat", c.logAttrs("error", err)...)
 		return
 	}
-	jsonBytes = append(jsonBytes, '\n')
-	if _, err := conn.Write(jsonBytes); err != nil {
+	if err := c.write(frame); err != nil {
 		s.metrics.mu.Lock()
 		s.metrics.HeartbeatFails++
 		s.metrics.mu.Unlock()
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to send heartbeat | RemoteAddr: %s | Time: %s | Error: %v",
-			conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05"), err))
+		s.logger.Warn("Failed to send heartbeat", c.logAttrs("error", err)...)
+		return
+	}
+	s.logger.Debug("Heartbeat sent", c.logAttrs()...)
+}
+
+// evictIdle closes a connection that has been silent for longer than HeartbeatTimeout. A peer
+// waiting for answers is not idle; the timer is re-armed when its last request finishes.
+func (s *Server) evictIdle(c *connection) {
+	if c.ctx.Err() != nil || len(c.sem) > 0 {
 		return
 	}
-	utility.LogAndPrint(fmt.Sprintf("RPC: Heartbeat sent | RemoteAddr: %s | Time: %s",
-		conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05")))
+	s.metrics.mu.Lock()
+	s.metrics.IdleEvictions++
+	s.metrics.mu.Unlock()
+	s.logger.Info("Evicting idle connection", c.logAttrs("heartbeat_timeout", s.config.HeartbeatTimeout)...)
+	c.conn.Close()
 }
 
 func isClosedError(err error) bool {
@@ -62,15 +74,31 @@ func isClosedError(err error) bool {
 }
 
 func (s *Server) GetMetrics() Metrics {
+	breakers := s.registry.breakerStats()
+	patterns := s.stats.snapshot()
+
 	s.metrics.mu.Lock()
 	defer s.metrics.mu.Unlock()
 
 	return Metrics{
-		RequestsTotal:   s.metrics.RequestsTotal,
-		ErrorsTotal:     s.metrics.ErrorsTotal,
-		ActiveConns:     s.metrics.ActiveConns,
-		ProcessingTime:  s.metrics.ProcessingTime,
-		HeartbeatsTotal: s.metrics.HeartbeatsTotal,
-		HeartbeatFails:  s.metrics.HeartbeatFails,
+		RequestsTotal:      s.metrics.RequestsTotal,
+		EventsTotal:        s.metrics.EventsTotal,
+		ErrorsTotal:        s.metrics.ErrorsTotal,
+		TimeoutsTotal:      s.metrics.TimeoutsTotal,
+		PanicsTotal:        s.metrics.PanicsTotal,
+		ActiveConns:        s.metrics.ActiveConns,
+		ProcessingTime:     s.metrics.ProcessingTime,
+		HeartbeatsTotal:    s.metrics.HeartbeatsTotal,
+		HeartbeatFails:     s.metrics.HeartbeatFails,
+		IdleEvictions:      s.metrics.IdleEvictions,
+		OversizedFrames:    s.metrics.OversizedFrames,
+		WritesDropped:      s.metrics.WritesDropped,
+		WritesBlocked:      s.metrics.WritesBlocked,
+		SlowConsumers:      s.metrics.SlowConsumers,
+		TLSHandshakeErrors: s.metrics.TLSHandshakeErrors,
+		RateLimitedTotal:   s.metrics.RateLimitedTotal,
+		Draining:           s.State() == StateDraining,
+		CircuitBreakers:    breakers,
+		Patterns:           patterns,
 	}
 }
@@ -0,0 +1,199 @@
+package rpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsHandler serves the server's metrics in the Prometheus text exposition format
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := s.WritePrometheus(w); err != nil {
+			s.logger.Warn("Failed to write metrics", "error", err)
+		}
+	})
+}
+
+// WritePrometheus writes the server's metrics to w in the Prometheus text exposition format
+func (s *Server) WritePrometheus(w io.Writer) error {
+	m := s.GetMetrics()
+	bw := bufio.NewWriter(w)
+	p := &promWriter{w: bw}
+
+	p.metric("rpc_requests_total", "counter", "Requests received, including unknown patterns.", float64(m.RequestsTotal))
+	p.metric("rpc_events_total", "counter", "Events received.", float64(m.EventsTotal))
+	p.metric("rpc_errors_total", "counter", "Requests, events and frames that failed.", float64(m.ErrorsTotal))
+	p.metric("rpc_timeouts_total", "counter", "Requests that exceeded their deadline.", float64(m.TimeoutsTotal))
+	p.metric("rpc_panics_total", "counter", "Handler panics recovered.", float64(m.PanicsTotal))
+	p.metric("rpc_rate_limited_total", "counter", "Requests and events rejected by a rate limit.", float64(m.RateLimitedTotal))
+	p.metric("rpc_active_connections", "gauge", "Open client connections.", float64(m.ActiveConns))
+	p.metric("rpc_heartbeats_total", "counter", "Pings received from clients.", float64(m.HeartbeatsTotal))
+	p.metric("rpc_heartbeat_failures_total", "counter", "Heartbeats that could not be sent.", float64(m.HeartbeatFails))
+	p.metric("rpc_idle_evictions_total", "counter", "Connections closed for exceeding HeartbeatTimeout.", float64(m.IdleEvictions))
+	p.metric("rpc_oversized_frames_total", "counter", "Frames rejected for exceeding MaxMessageBytes.", float64(m.OversizedFrames))
+	p.metric("rpc_writes_dropped_total", "counter", "Frames that could not be written.", float64(m.WritesDropped))
+	p.metric("rpc_writes_blocked_total", "counter", "Writes that hit WriteTimeout.", float64(m.WritesBlocked))
+	p.metric("rpc_slow_consumers_total", "counter", "Connections closed because their write queue filled up.", float64(m.SlowConsumers))
+	p.metric("rpc_tls_handshake_errors_total", "counter", "Failed TLS handshakes.", float64(m.TLSHandshakeErrors))
+	draining := 0.0
+	if m.Draining {
+		draining = 1
+	}
+	p.metric("rpc_draining", "gauge", "1 while Shutdown is draining connections.", draining)
+
+	patterns := make([]string, 0, len(m.Patterns))
+	for pattern := range m.Patterns {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	p.header("rpc_pattern_requests_total", "counter", "Requests handled per pattern.")
+	for _, pattern := range patterns {
+		p.sample("rpc_pattern_requests_total", float64(m.Patterns[pattern].Requests), "pattern", pattern)
+	}
+
+	p.header("rpc_pattern_errors_total", "counter", "Failed requests per pattern and status code.")
+	for _, pattern := range patterns {
+		stats := m.Patterns[pattern]
+		codes := make([]int, 0, len(stats.Errors))
+		for code := range stats.Errors {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			p.sample("rpc_pattern_errors_total", float64(stats.Errors[code]), "pattern", pattern, "code", strconv.Itoa(code))
+		}
+	}
+
+	p.header("rpc_pattern_in_flight", "gauge", "Requests currently being handled per pattern.")
+	for _, pattern := range patterns {
+		p.sample("rpc_pattern_in_flight", float64(m.Patterns[pattern].InFlight), "pattern", pattern)
+	}
+
+	p.header("rpc_pattern_duration_seconds", "histogram", "Request handling latency per pattern.")
+	bounds := s.stats.bounds
+	for _, pattern := range patterns {
+		stats := m.Patterns[pattern]
+		for i, bound := range bounds {
+			p.sample("rpc_pattern_duration_seconds_bucket", float64(stats.Buckets[i]), "pattern", pattern, "le", formatFloat(bound))
+		}
+		p.sample("rpc_pattern_duration_seconds_bucket", float64(stats.LatencyCount), "pattern", pattern, "le", "+Inf")
+		p.sample("rpc_pattern_duration_seconds_sum", stats.LatencySum.Seconds(), "pattern", pattern)
+		p.sample("rpc_pattern_duration_seconds_count", float64(stats.LatencyCount), "pattern", pattern)
+	}
+
+	breakers := make([]string, 0, len(m.CircuitBreakers))
+	for pattern := range m.CircuitBreakers {
+		breakers = append(breakers, pattern)
+	}
+	sort.Strings(breakers)
+	if len(breakers) > 0 {
+		p.header("rpc_circuit_breaker_state", "gauge", "Circuit breaker state per pattern: 0 closed, 1 open, 2 half-open.")
+		for _, pattern := range breakers {
+			p.sample("rpc_circuit_breaker_state", float64(m.CircuitBreakers[pattern].State), "pattern", pattern)
+		}
+		p.header("rpc_circuit_breaker_rejected_total", "counter", "Calls rejected by an open circuit breaker.")
+		for _, pattern := range breakers {
+			p.sample("rpc_circuit_breaker_rejected_total", float64(m.CircuitBreakers[pattern].Rejected), "pattern", pattern)
+		}
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+	return bw.Flush()
+}
+
+// promWriter writes the text exposition format, keeping the first error
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (p *promWriter) header(name, kind, help string) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *promWriter) metric(name, kind, help string, value float64) {
+	p.header(name, kind, help)
+	p.sample(name, value)
+}
+
+// sample writes one line; labels are name/value pairs
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	if p.err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	_, p.err = p.w.WriteString(b.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+// startMetricsServer serves MetricsHandler on Config.MetricsAddr at /metrics
+func (s *Server) startMetricsServer() error {
+	s.metricsOnce.Do(func() {
+		if s.config.MetricsAddr == "" {
+			return
+		}
+		listener, err := net.Listen("tcp", s.config.MetricsAddr)
+		if err != nil {
+			s.metricsErr = fmt.Errorf("failed to listen on %s: %w", s.config.MetricsAddr, err)
+			return
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		s.mu.Lock()
+		if s.State() >= StateDraining {
+			s.mu.Unlock()
+			listener.Close()
+			s.metricsErr = ErrServerClosed
+			return
+		}
+		s.metricsServer = server
+		s.mu.Unlock()
+
+		s.logger.Info("Metrics endpoint listening", "address", listener.Addr().String())
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("Metrics endpoint failed", "error", err)
+			}
+		}()
+	})
+	return s.metricsErr
+}
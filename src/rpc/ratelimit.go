@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipLimiterIdle is how long an unused per-IP limiter is kept before it is pruned
+const ipLimiterIdle = 10 * time.Minute
+
+// WithRateLimit limits how often one pattern may be called, across all clients
+func WithRateLimit(perSec float64, burst int) PatternOption {
+	return func(r *route) {
+		r.limiter = rate.NewLimiter(rate.Limit(perSec), burst)
+	}
+}
+
+// RateLimited is the error returned to a client that exceeded a rate limit
+func RateLimited(retryAfter time.Duration) *Error {
+	return NewError(CodeTooManyRequests, "Rate limit exceeded").
+		WithDetail("retryAfter", (retryAfter + time.Millisecond - 1).Milliseconds())
+}
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipLimiters hands out one limiter per remote IP, dropping those idle for a while
+type ipLimiters struct {
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+	entries   map[string]*ipLimiter
+	lastPrune time.Time
+}
+
+func newIPLimiters(perSec float64, burst int) *ipLimiters {
+	return &ipLimiters{
+		limit:     rate.Limit(perSec),
+		burst:     burst,
+		entries:   make(map[string]*ipLimiter),
+		lastPrune: time.Now(),
+	}
+}
+
+func (l *ipLimiters) get(addr net.Addr) *rate.Limiter {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= time.Minute {
+		l.lastPrune = now
+		for key, entry := range l.entries {
+			if now.Sub(entry.lastSeen) >= ipLimiterIdle {
+				delete(l.entries, key)
+			}
+		}
+	}
+
+	entry, ok := l.entries[ip]
+	if !ok {
+		entry = &ipLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[ip] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// reserve takes one token from every limiter or from none. It returns how long the caller
+// should wait before retrying when any of them is exhausted.
+func reserve(limiters ...*rate.Limiter) (time.Duration, bool) {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	var retryAfter time.Duration
+	ok := true
+	for _, lim := range limiters {
+		if lim == nil {
+			continue
+		}
+		r := lim.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if !r.OK() {
+			ok = false
+			retryAfter = time.Second
+			continue
+		}
+		if delay := r.DelayFrom(now); delay > 0 {
+			ok = false
+			if delay > retryAfter {
+				retryAfter = delay
+			}
+		}
+	}
+
+	if !ok {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	return retryAfter, ok
+}
+
+// admit applies the global, per-IP, per-connection and per-pattern rate limits to req
+func (s *Server) admit(c *connection, req *Request) bool {
+	var patternLimiter *rate.Limiter
+	if req.ID != "" {
+		if rt, ok := s.registry.route(req.Pattern.Cmd); ok {
+			patternLimiter = rt.limiter
+		}
+	}
+
+	var ipLimiter *rate.Limiter
+	if s.ipLimiters != nil {
+		ipLimiter = s.ipLimiters.get(c.conn.RemoteAddr())
+	}
+
+	retryAfter, ok := reserve(s.limiter, ipLimiter, c.limiter, patternLimiter)
+	if ok {
+		return true
+	}
+
+	s.metrics.mu.Lock()
+	s.metrics.RateLimitedTotal++
+	s.metrics.mu.Unlock()
+	s.logger.Warn("Rate limited",
+		c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID, "retry_after", retryAfter)...)
+
+	// events get no reply, rate limited or not
+	if req.ID != "" {
+		s.sendError(c, req.Pattern.Cmd, req.ID, RateLimited(retryAfter))
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestConnectionRateLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		perSec      int
+		burst       int
+		wantLimited bool
+	}{
+		{"off by default", 0, 0, false},
+		{"opted in", 10, 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, RateLimitPerSec: tt.perSec, RateLimitBurst: tt.burst})
+			s.RegisterHandler("echo", func(data json.RawMessage) (interface{}, error) { return data, nil })
+			addr, _ := serve(t, s)
+			defer shutdown(t, s)
+			client := dial(t, addr)
+
+			limited := 0
+			for i := 0; i < 400; i++ {
+				_, err := client.Send(context.Background(), "echo", i)
+				var rpcErr *Error
+				switch {
+				case errors.As(err, &rpcErr) && rpcErr.Code == CodeTooManyRequests:
+					limited++
+				case err != nil:
+					t.Fatal(err)
+				}
+			}
+			if (limited > 0) != tt.wantLimited {
+				t.Fatalf("%d of 400 requests were rate limited", limited)
+			}
+			if got := s.GetMetrics().RateLimitedTotal; got != uint64(limited) {
+				t.Fatalf("RateLimitedTotal = %d, want %d", got, limited)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"runtime/debug"
+)
+
+// PanicHandler lets the application report handler panics (e.g. to Sentry)
+type PanicHandler func(ctx *Context, recovered interface{}, stack []byte)
+
+// recoverPanic must be deferred around handler calls; it turns a panic into an internal error
+// so one faulty handler cannot take the whole process down
+func (s *Server) recoverPanic(ctx *Context, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+
+	s.metrics.mu.Lock()
+	s.metrics.PanicsTotal++
+	s.metrics.mu.Unlock()
+	s.logger.Error("Panic in handler", "conn_id", ctx.ConnID, "remote_addr", ctx.RemoteAddr.String(),
+		"pattern", ctx.Request.Pattern.Cmd, "request_id", ctx.Request.ID, "recovered", r, "stack", string(stack))
+
+	if s.config.PanicHandler != nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					s.logger.Error("Panic in PanicHandler", "recovered", r)
+				}
+			}()
+			s.config.PanicHandler(ctx, r, stack)
+		}()
+	}
+	// a fresh value each time: middleware may add details to the error it gets
+	*err = Internal("Internal server error")
+}
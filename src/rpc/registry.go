@@ -1,25 +1,181 @@
 package rpc
 
-import "sync"
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
 
 type Registry struct {
-	handlers map[string]MessageHandler
+	handlers map[string]*route
+	events   map[string][]eventRoute
 	mu       sync.RWMutex
+	logger   Logger
+}
+
+// route is a registered message handler together with its per-pattern settings
+type route struct {
+	handler    ContextHandler
+	message    MessageHandler
+	timeout    time.Duration
+	middleware []Middleware
+	stream     bool
+	limiter    *rate.Limiter
+	retry      *RetryPolicy
+	breaker    *circuitBreaker
+}
+
+// eventRoute is a subscribed event handler; plain is set when it was registered without a Context
+type eventRoute struct {
+	handler EventContextHandler
+	plain   EventHandler
+}
+
+// PatternOption customises how a single pattern is handled
+type PatternOption func(*route)
+
+// WithTimeout overrides Config.Timeout for one pattern
+func WithTimeout(timeout time.Duration) PatternOption {
+	return func(r *route) {
+		r.timeout = timeout
+	}
 }
 
 func NewRegistry() *Registry {
-	return &Registry{handlers: make(map[string]MessageHandler)}
+	return &Registry{
+		handlers: make(map[string]*route),
+		events:   make(map[string][]eventRoute),
+		logger:   defaultLogger(),
+	}
+}
+
+func (r *Registry) Register(pattern string, handler MessageHandler, opts ...PatternOption) {
+	r.add(pattern, &route{handler: AdaptHandler(handler), message: handler}, opts)
 }
 
-func (r *Registry) Register(pattern string, handler MessageHandler) {
+func (r *Registry) RegisterContext(pattern string, handler ContextHandler, opts ...PatternOption) {
+	r.add(pattern, &route{handler: handler}, opts)
+}
+
+// RegisterStream registers a handler that may send several responses to one request
+func (r *Registry) RegisterStream(pattern string, handler StreamHandler, opts ...PatternOption) {
+	r.add(pattern, &route{handler: adaptStream(handler), stream: true}, opts)
+}
+
+func (r *Registry) add(pattern string, rt *route, opts []PatternOption) {
+	for _, opt := range opts {
+		opt(rt)
+	}
+	if rt.breaker != nil {
+		rt.breaker.pattern = pattern
+		rt.breaker.logger = r.logger
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.handlers[pattern] = handler
+	r.handlers[pattern] = rt
 }
 
+// Get returns the handler for pattern as a MessageHandler. Handlers registered with a
+// Context run with a background context and only the request data filled in; use
+// GetContext to call them with a full Context.
 func (r *Registry) Get(pattern string) (MessageHandler, bool) {
+	rt, ok := r.route(pattern)
+	if !ok {
+		return nil, false
+	}
+	if rt.message != nil {
+		return rt.message, true
+	}
+	handler := rt.handler
+	return func(data json.RawMessage) (interface{}, error) {
+		return handler(&Context{Context: context.Background(), Request: &Request{Data: data}})
+	}, true
+}
+
+// GetContext returns the handler for pattern with the signature the server calls it with
+func (r *Registry) GetContext(pattern string) (ContextHandler, bool) {
+	rt, ok := r.route(pattern)
+	if !ok {
+		return nil, false
+	}
+	return rt.handler, true
+}
+
+func (r *Registry) route(pattern string) (*route, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rt, ok := r.handlers[pattern]
+	return rt, ok
+}
+
+// breakerStats snapshots the circuit breakers of all patterns that have one
+func (r *Registry) breakerStats() map[string]BreakerStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make(map[string]BreakerStats)
+	for pattern, rt := range r.handlers {
+		if rt.breaker != nil {
+			stats[pattern] = rt.breaker.stats()
+		}
+	}
+	return stats
+}
+
+// RegisterEvent subscribes handler to pattern; several handlers may share one event
+func (r *Registry) RegisterEvent(pattern string, handler EventHandler) {
+	r.addEvent(pattern, eventRoute{handler: AdaptEventHandler(handler), plain: handler})
+}
+
+// RegisterEventContext subscribes a handler that also receives the request metadata and trace
+func (r *Registry) RegisterEventContext(pattern string, handler EventContextHandler) {
+	r.addEvent(pattern, eventRoute{handler: handler})
+}
+
+func (r *Registry) addEvent(pattern string, ev eventRoute) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[pattern] = append(r.events[pattern], ev)
+}
+
+// GetEvent returns the handlers subscribed to pattern as EventHandlers. Handlers registered
+// with a Context run with a background context and only the request data filled in; use
+// GetEventContext to call them with a full Context.
+func (r *Registry) GetEvent(pattern string) ([]EventHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	evs, ok := r.events[pattern]
+	if !ok {
+		return nil, false
+	}
+	handlers := make([]EventHandler, 0, len(evs))
+	for _, ev := range evs {
+		if ev.plain != nil {
+			handlers = append(handlers, ev.plain)
+			continue
+		}
+		handler := ev.handler
+		handlers = append(handlers, func(data json.RawMessage) error {
+			return handler(&Context{Context: context.Background(), Request: &Request{Data: data}})
+		})
+	}
+	return handlers, true
+}
+
+// GetEventContext returns the handlers subscribed to pattern with the signature the server calls them with
+func (r *Registry) GetEventContext(pattern string) ([]EventContextHandler, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	h, ok := r.handlers[pattern]
-	return h, ok
+	evs, ok := r.events[pattern]
+	if !ok {
+		return nil, false
+	}
+	handlers := make([]EventContextHandler, 0, len(evs))
+	for _, ev := range evs {
+		handlers = append(handlers, ev.handler)
+	}
+	return handlers, true
 }
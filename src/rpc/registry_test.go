@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistryGet(t *testing.T) {
+	r := NewRegistry()
+	r.Register("plain", func(data json.RawMessage) (interface{}, error) {
+		return "plain " + string(data), nil
+	})
+	r.RegisterContext("ctx", func(ctx *Context) (interface{}, error) {
+		return "ctx " + string(ctx.Request.Data), nil
+	})
+
+	for _, pattern := range []string{"plain", "ctx"} {
+		handler, ok := r.Get(pattern)
+		if !ok {
+			t.Fatalf("Get(%q) found nothing", pattern)
+		}
+		out, err := handler(json.RawMessage(`1`))
+		if err != nil || out != pattern+" 1" {
+			t.Fatalf("Get(%q) handler = %v, %v", pattern, out, err)
+		}
+
+		ctxHandler, ok := r.GetContext(pattern)
+		if !ok {
+			t.Fatalf("GetContext(%q) found nothing", pattern)
+		}
+		out, err = ctxHandler(&Context{Request: &Request{Data: json.RawMessage(`2`)}})
+		if err != nil || out != pattern+" 2" {
+			t.Fatalf("GetContext(%q) handler = %v, %v", pattern, out, err)
+		}
+	}
+
+	if _, ok := r.Get("missing"); ok {
+		t.Fatal("Get found an unregistered pattern")
+	}
+	if _, ok := r.GetContext("missing"); ok {
+		t.Fatal("GetContext found an unregistered pattern")
+	}
+}
+
+func TestRegistryGetEvent(t *testing.T) {
+	r := NewRegistry()
+	var got []string
+	r.RegisterEvent("evt", func(data json.RawMessage) error {
+		got = append(got, "plain "+string(data))
+		return nil
+	})
+	r.RegisterEventContext("evt", func(ctx *Context) error {
+		got = append(got, "ctx "+string(ctx.Request.Data))
+		return nil
+	})
+
+	handlers, ok := r.GetEvent("evt")
+	if !ok || len(handlers) != 2 {
+		t.Fatalf("GetEvent = %d handlers, %t", len(handlers), ok)
+	}
+	for _, handler := range handlers {
+		if err := handler(json.RawMessage(`1`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctxHandlers, ok := r.GetEventContext("evt")
+	if !ok || len(ctxHandlers) != 2 {
+		t.Fatalf("GetEventContext = %d handlers, %t", len(ctxHandlers), ok)
+	}
+	for _, handler := range ctxHandlers {
+		if err := handler(&Context{Request: &Request{Data: json.RawMessage(`2`)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"plain 1", "ctx 1", "plain 2", "ctx 2"}
+	if len(got) != len(want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", got, want)
+		}
+	}
+	if _, ok := r.GetEventContext("missing"); ok {
+		t.Fatal("GetEventContext found an unregistered pattern")
+	}
+}
@@ -1,59 +1,43 @@
 package rpc
 
-import (
-	"encoding/json"
-	"fmt"
-	"net"
-	"time"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
-)
-
-// sendResponse sends a successful response using length-prefixed framing: "<len>#<json>"
-func (s *Server) sendResponse(conn net.Conn, pattern string, resp Response) {
-	jsonBytes, err := json.Marshal(resp)
+// sendResponse sends a successful response using the connection's codec and framing
+func (s *Server) sendResponse(c *connection, pattern string, resp Response) {
+	frame, err := c.encode(resp)
 	if err != nil {
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to marshal response | Error: %v", err))
+		s.logger.Error("Failed to marshal response", c.logAttrs("pattern", pattern, "request_id", resp.Id, "error", err)...)
 		return
 	}
 
-	// Add length prefix like NestJS expects
-	framed := fmt.Sprintf("%d#", len(jsonBytes)) + string(jsonBytes)
-
-	if _, err := conn.Write([]byte(framed)); err != nil {
+	if err := c.write(frame); err != nil {
 		s.metrics.mu.Lock()
 		s.metrics.ErrorsTotal++
 		s.metrics.mu.Unlock()
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to send response | Pattern: %s | RemoteAddr: %s | Time: %s | Error: %v",
-			pattern, conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05"), err))
+		s.logger.Warn("Failed to send response", c.logAttrs("pattern", pattern, "request_id", resp.Id, "error", err)...)
 		return
 	}
 
-	utility.LogAndPrint(fmt.Sprintf("RPC: Response sent | Pattern: %s | RemoteAddr: %s | Time: %s",
-		pattern, conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05")))
+	s.logger.Debug("Response sent", c.logAttrs("pattern", pattern, "request_id", resp.Id)...)
 }
 
-// sendError sends an error response using length-prefixed framing
-func (s *Server) sendError(conn net.Conn, pattern, msg string) {
-	resp := Response{Err: msg, Status: "error", IsDisposed: true}
-	jsonBytes, err := json.Marshal(resp)
+// sendError sends a structured error response using the connection's codec and framing.
+// id is the request being answered; it is empty only when the frame was too broken to
+// recover one, in which case the client cannot correlate the error to a pending call.
+func (s *Server) sendError(c *connection, pattern, id string, rpcErr *Error) {
+	resp := Response{Id: id, Err: rpcErr, Status: "error", IsDisposed: true}
+	frame, err := c.encode(resp)
 	if err != nil {
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to marshal error response | Error: %v", err))
+		s.logger.Error("Failed to marshal error response", c.logAttrs("pattern", pattern, "request_id", id, "error", err)...)
 		return
 	}
 
-	// Add length prefix like NestJS expects
-	framed := fmt.Sprintf("%d#", len(jsonBytes)) + string(jsonBytes)
-
-	if _, err := conn.Write([]byte(framed)); err != nil {
+	if err := c.write(frame); err != nil {
 		s.metrics.mu.Lock()
 		s.metrics.ErrorsTotal++
 		s.metrics.mu.Unlock()
-		utility.LogAndPrint(fmt.Sprintf("RPC: Failed to send error response | Pattern: %s | RemoteAddr: %s | Time: %s | Error: %v",
-			pattern, conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05"), err))
+		s.logger.Warn("Failed to send error response", c.logAttrs("pattern", pattern, "request_id", id, "error", err)...)
 		return
 	}
 
-	utility.LogAndPrint(fmt.Sprintf("RPC: Error response sent | Pattern: %s | RemoteAddr: %s | Time: %s | Message: %s",
-		pattern, conn.RemoteAddr().String(), time.Now().Format("2006-01-02 15:04:05"), msg))
+	s.logger.Debug("Error response sent",
+		c.logAttrs("pattern", pattern, "request_id", id, "status", rpcErr.Code, "message", rpcErr.Message)...)
 }
@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how a failed handler is retried. Only errors marked with Retryable
+// are retried; everything else is returned to the caller straight away.
+type RetryPolicy struct {
+	// MaxAttempts is the number of retries after the first call
+	MaxAttempts int
+	// InitialDelay is the wait before the first retry; each further wait is Multiplier times longer
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter randomises each wait by up to this fraction of it (0.2 = ±20%)
+	Jitter float64
+	// MaxElapsed stops retrying once this much time has passed since the first call
+	MaxElapsed time.Duration
+}
+
+// WithRetryPolicy overrides the server's retry policy for one pattern
+func WithRetryPolicy(policy RetryPolicy) PatternOption {
+	return func(r *route) {
+		r.retry = &policy
+	}
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient so the retry policy may run the handler again
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether err, or any error it wraps, was marked with Retryable
+func IsRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable)
+}
+
+// defaultRetryPolicy builds the server-wide policy from RetryAttempts and RetryDelay
+func defaultRetryPolicy(config *Config) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  config.RetryAttempts,
+		InitialDelay: config.RetryDelay,
+		MaxDelay:     30 * config.RetryDelay,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// delay returns the wait before retry number attempt (0-based)
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialDelay)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 0; i < attempt; i++ {
+		d *= multiplier
+		if p.MaxDelay > 0 && d >= float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// withRetry re-runs handler on retryable errors according to policy. The waits happen on the
+// request's own goroutine and end early when the request deadline would pass first.
+func (s *Server) withRetry(handler ContextHandler, policy *RetryPolicy) ContextHandler {
+	return func(ctx *Context) (interface{}, error) {
+		start := time.Now()
+		for attempt := 0; ; attempt++ {
+			attemptStart := time.Now()
+			result, handlerErr := handler(ctx)
+			if handlerErr == nil {
+				s.metrics.mu.Lock()
+				s.metrics.ProcessingTime += time.Since(attemptStart)
+				s.metrics.mu.Unlock()
+				return result, nil
+			}
+			if attempt >= policy.MaxAttempts || !IsRetryable(handlerErr) {
+				return result, handlerErr
+			}
+
+			wait := policy.delay(attempt)
+			if policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed {
+				return result, handlerErr
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+				return result, handlerErr
+			}
+
+			s.logger.Info("Handler retry", "conn_id", ctx.ConnID, "pattern", ctx.Request.Pattern.Cmd,
+				"request_id", ctx.Request.ID, "attempt", attempt+1, "delay", wait, "error", handlerErr)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, handlerErr
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAttemptsDefault(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     int
+	}{
+		{0, 3},
+		{-1, 0},
+		{1, 1},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		config := applyDefaults(&Config{RetryAttempts: tt.attempts})
+		if config.RetryAttempts != tt.want || config.RetryPolicy.MaxAttempts != tt.want {
+			t.Errorf("RetryAttempts %d: got %d, policy %d, want %d",
+				tt.attempts, config.RetryAttempts, config.RetryPolicy.MaxAttempts, tt.want)
+		}
+	}
+}
+
+func TestRetryableHandlerCalls(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		wantCalls int32
+	}{
+		{"default", 0, 4},
+		{"disabled", -1, 1},
+		{"one retry", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, RetryAttempts: tt.attempts, RetryDelay: time.Millisecond})
+			var calls atomic.Int32
+			s.RegisterHandler("flaky", func(json.RawMessage) (interface{}, error) {
+				calls.Add(1)
+				return nil, Retryable(errors.New("try again"))
+			})
+			addr, _ := serve(t, s)
+			defer shutdown(t, s)
+
+			if _, err := dial(t, addr).Send(context.Background(), "flaky", nil); err == nil {
+				t.Fatal("Send succeeded")
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Fatalf("handler called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
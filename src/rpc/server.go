@@ -1,7 +1,7 @@
 package rpc
 
 import (
-	"net"
+	"context"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -12,6 +12,9 @@ func applyDefaults(config *Config) *Config {
 		config = &Config{}
 	}
 
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
 	if config.Addr == "" {
 		config.Addr = ":8080"
 	}
@@ -21,41 +24,109 @@ func applyDefaults(config *Config) *Config {
 	if config.MaxConnections <= 0 {
 		config.MaxConnections = 1000
 	}
-	if config.RateLimitPerSec <= 0 {
-		config.RateLimitPerSec = 100
+	if config.MaxInFlightPerConn <= 0 {
+		config.MaxInFlightPerConn = 64
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+	if config.WriteQueueSize <= 0 {
+		config.WriteQueueSize = 256
+	}
+	if config.Codec == nil {
+		config.Codec = JSON
+	}
+	if config.MaxMessageBytes <= 0 {
+		config.MaxMessageBytes = 16 << 20
+	}
+	if config.ReadBufferSize <= 0 {
+		config.ReadBufferSize = 32 << 10
 	}
-	if config.RateLimitBurst <= 0 {
-		config.RateLimitBurst = 200
+	if config.RateLimitPerSec > 0 && config.RateLimitBurst <= 0 {
+		config.RateLimitBurst = config.RateLimitPerSec
 	}
-	if config.RetryAttempts < 0 {
+	if config.GlobalRateLimitPerSec > 0 && config.GlobalRateLimitBurst <= 0 {
+		config.GlobalRateLimitBurst = config.GlobalRateLimitPerSec
+	}
+	if config.IPRateLimitPerSec > 0 && config.IPRateLimitBurst <= 0 {
+		config.IPRateLimitBurst = config.IPRateLimitPerSec
+	}
+	switch {
+	case config.RetryAttempts == 0:
 		config.RetryAttempts = 3
+	case config.RetryAttempts < 0:
+		// negative disables retries
+		config.RetryAttempts = 0
 	}
 	if config.RetryDelay <= 0 {
 		config.RetryDelay = 500 * time.Millisecond
 	}
+	if config.RetryPolicy == nil {
+		policy := defaultRetryPolicy(config)
+		config.RetryPolicy = &policy
+	}
+	if len(config.LatencyBuckets) == 0 {
+		config.LatencyBuckets = DefaultLatencyBuckets
+	}
 	if config.HeartbeatInterval <= 0 {
 		config.HeartbeatInterval = 15 * time.Second
 	}
 	if config.HeartbeatTimeout <= 0 {
 		config.HeartbeatTimeout = 45 * time.Second
 	}
+	if config.Logger == nil {
+		config.Logger = defaultLogger()
+	}
 
 	return config
 }
 
 func NewServer(config *Config) *Server {
 	config = applyDefaults(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	logger := config.Logger
+	if config.LogSampling != nil {
+		logger = NewSampledLogger(logger, *config.LogSampling)
+	}
 
-	return &Server{
+	s := &Server{
 		registry:     NewRegistry(),
 		config:       config,
-		activeConns:  make(map[net.Conn]struct{}),
-		limiter:      rate.NewLimiter(rate.Limit(config.RateLimitPerSec), config.RateLimitBurst),
+		activeConns:  make(map[*connection]struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
 		metrics:      &Metrics{},
+		stats:        newStatsRegistry(config.LatencyBuckets),
 		shutdownChan: make(chan struct{}),
+		logger:       logger,
+	}
+	s.registry.logger = logger
+	// the per-connection limiter is made in newConnection; all limits are off unless set
+	if config.GlobalRateLimitPerSec > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(config.GlobalRateLimitPerSec), config.GlobalRateLimitBurst)
+	}
+	if config.IPRateLimitPerSec > 0 {
+		s.ipLimiters = newIPLimiters(float64(config.IPRateLimitPerSec), config.IPRateLimitBurst)
 	}
+	return s
+}
+
+func (s *Server) RegisterHandler(pattern string, handler MessageHandler, opts ...PatternOption) {
+	s.registry.Register(pattern, handler, opts...)
+}
+
+func (s *Server) RegisterContextHandler(pattern string, handler ContextHandler, opts ...PatternOption) {
+	s.registry.RegisterContext(pattern, handler, opts...)
+}
+
+func (s *Server) RegisterStreamHandler(pattern string, handler StreamHandler, opts ...PatternOption) {
+	s.registry.RegisterStream(pattern, handler, opts...)
+}
+
+func (s *Server) RegisterEventHandler(pattern string, handler EventHandler) {
+	s.registry.RegisterEvent(pattern, handler)
 }
 
-func (s *Server) RegisterHandler(pattern string, handler MessageHandler) {
-	s.registry.Register(pattern, handler)
+func (s *Server) RegisterEventContextHandler(pattern string, handler EventContextHandler) {
+	s.registry.RegisterEventContext(pattern, handler)
 }
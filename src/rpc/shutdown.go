@@ -3,34 +3,58 @@ package rpc
 import (
 	"context"
 	"fmt"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
 )
 
+// Shutdown drains the server in phases: it stops accepting connections, notifies clients through
+// Config.DrainNotifier, stops reading new frames and lets in-flight handlers finish and flush
+// their responses. Whatever is still running when ctx is done is cancelled and force-closed.
+// Calling it again waits for the first call to finish.
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
-	s.isShuttingDown = true
-	if s.listener != nil {
-		utility.LogAndPrint("RPC: Server shutting down...")
-		if err := s.listener.Close(); err != nil && !isClosedError(err) {
-			s.mu.Unlock()
-			utility.LogAndPrint(fmt.Sprintf("RPC: Failed to close listener | Error: %v", err))
-			return fmt.Errorf("failed to close listener: %w", err)
+	if s.State() >= StateDraining {
+		s.mu.Unlock()
+		select {
+		case <-s.shutdownChan:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// connections accepted from here on are closed straight away
+	s.state.Store(int32(StateDraining))
+	s.logger.Info("Server shutting down")
+	var closeErr error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && !isClosedError(err) {
+			s.logger.Error("Failed to close listener", "address", listener.Addr().String(), "error", err)
+			if closeErr == nil {
+				closeErr = fmt.Errorf("failed to close listener: %w", err)
+			}
 		}
 	}
+	metricsServer := s.metricsServer
 	s.mu.Unlock()
 
-	// Close all active connections
 	s.connMu.Lock()
-	for conn := range s.activeConns {
-		if err := conn.Close(); err != nil && !isClosedError(err) {
-			utility.LogAndPrint(fmt.Sprintf("RPC: Failed to close connection | Error: %v", err))
-		}
+	conns := make([]*connection, 0, len(s.activeConns))
+	for c := range s.activeConns {
+		conns = append(conns, c)
 	}
 	s.connMu.Unlock()
+	s.logger.Info("Draining connections", "connections", len(conns))
 
-	// Signal all goroutines (heartbeat, etc.) to exit
-	close(s.shutdownChan)
+	// each connection closes itself once its in-flight requests have been answered. Connections
+	// are notified independently so a slow consumer holds up nobody else's drain.
+	for _, c := range conns {
+		if s.config.DrainNotifier == nil {
+			c.stopReading()
+			continue
+		}
+		go func(c *connection) {
+			s.notifyDrain(ctx, c)
+			c.stopReading()
+		}(c)
+	}
 
 	// Wait for all goroutines to finish
 	done := make(chan struct{})
@@ -39,12 +63,43 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		close(done)
 	}()
 
+	var err error
 	select {
 	case <-done:
-		utility.LogAndPrint("RPC: Server shutdown complete")
-		return nil
 	case <-ctx.Done():
-		utility.LogAndPrint(fmt.Sprintf("RPC: Shutdown timeout | Error: %v", ctx.Err()))
-		return ctx.Err()
+		s.logger.Warn("Shutdown timeout, closing remaining connections", "error", ctx.Err())
+		err = ctx.Err()
+	}
+
+	// Cancel handler contexts and close whatever is left
+	s.cancel()
+	s.connMu.Lock()
+	for c := range s.activeConns {
+		if err := c.conn.Close(); err != nil && !isClosedError(err) {
+			s.logger.Warn("Failed to close connection", c.logAttrs("error", err)...)
+		}
+	}
+	s.connMu.Unlock()
+
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			s.logger.Error("Failed to close metrics endpoint", "error", err)
+		}
+	}
+
+	if err == nil {
+		err = closeErr
+	}
+	select {
+	case <-done:
+		s.stop(err)
+		s.logger.Info("Server shutdown complete")
+	default:
+		// force-closed connections exit shortly; the server counts as stopped once they have
+		go func() {
+			<-done
+			s.stop(err)
+		}()
 	}
+	return err
 }
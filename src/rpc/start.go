@@ -1,26 +1,119 @@
 package rpc
 
 import (
+	"errors"
 	"fmt"
 	"net"
-
-	"github.com/Ajinx1/go-message-pattern-server/src/utility"
+	"os"
 )
 
+// ErrServerClosed is returned by Serve once the server has been shut down
+var ErrServerClosed = errors.New("rpc: server closed")
+
+// Start listens on Config.Network/Config.Addr and accepts connections in the background
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.config.Addr)
+	switch s.State() {
+	case StateNew:
+	case StateRunning:
+		return ErrServerStarted
+	default:
+		return ErrServerClosed
+	}
+	if err := s.initTLS(); err != nil {
+		return err
+	}
+
+	if err := s.startMetricsServer(); err != nil {
+		return err
+	}
+
+	listener, err := listen(s.config.Network, s.config.Addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
 	}
+	if err := s.addListener(listener, false); err != nil {
+		listener.Close()
+		return err
+	}
 
-	s.mu.Lock()
-	s.listener = listener
-	s.mu.Unlock()
+	s.logger.Info("Server starting", "network", s.config.Network, "address", listener.Addr().String(),
+		"max_connections", s.config.MaxConnections, "rate_limit_per_sec", s.config.RateLimitPerSec,
+		"heartbeat_interval", s.config.HeartbeatInterval, "tls", s.tlsConfig != nil)
+
+	go s.acceptConnections(listener)
+	return nil
+}
 
-	utility.LogAndPrint(fmt.Sprintf("RPC: Server starting | Address: %s | MaxConnections: %d | RateLimitPerSec: %d | HeartbeatInterval: %s",
-		s.config.Addr, s.config.MaxConnections, s.config.RateLimitPerSec, s.config.HeartbeatInterval))
+// Serve accepts connections on l until the server shuts down, then returns ErrServerClosed.
+// It may be called for several listeners; all of them are closed by Shutdown. If l fails,
+// e.g. because the caller closed it, Serve returns the accept error.
+func (s *Server) Serve(l net.Listener) error {
+	if s.State() >= StateDraining {
+		return ErrServerClosed
+	}
+	if err := s.initTLS(); err != nil {
+		return err
+	}
+	if err := s.startMetricsServer(); err != nil {
+		return err
+	}
+	if err := s.addListener(l, true); err != nil {
+		return err
+	}
 
+	s.logger.Info("Serving", "network", l.Addr().Network(), "address", l.Addr().String(), "tls", s.tlsConfig != nil)
+
+	if err := s.acceptConnections(l); err != nil {
+		return err
+	}
+	return ErrServerClosed
+}
+
+func (s *Server) initTLS() error {
+	s.tlsOnce.Do(func() {
+		if s.config.TLS != nil {
+			s.tlsConfig, s.tlsErr = s.config.TLS.build(s.logger)
+		}
+	})
+	return s.tlsErr
+}
+
+// addListener registers l so Shutdown closes it; the accept loop must be started afterwards
+func (s *Server) addListener(l net.Listener, allowRunning bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.startServing(allowRunning); err != nil {
+		return err
+	}
+	s.listeners = append(s.listeners, l)
 	s.wg.Add(1)
-	go s.acceptConnections()
 	return nil
 }
+
+// removeListener forgets a listener whose accept loop has stopped
+func (s *Server) removeListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, listener := range s.listeners {
+		if listener == l {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// listen is net.Listen that also clears a stale Unix socket left behind by a crashed process
+func listen(network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", addr); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("socket %s is in use", addr)
+			}
+			if err := os.Remove(addr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(network, addr)
+}
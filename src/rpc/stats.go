@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// codeClientClosed marks requests the client cancelled or abandoned by disconnecting
+const codeClientClosed = 499
+
+// PatternStats is a snapshot of the request statistics of one pattern
+type PatternStats struct {
+	Requests uint64
+	// Errors counts failed requests by status code
+	Errors   map[int]uint64
+	InFlight int64
+	// Buckets holds cumulative counts for each bound of Config.LatencyBuckets
+	Buckets      []uint64
+	LatencySum   time.Duration
+	LatencyCount uint64
+}
+
+type patternStats struct {
+	mu       sync.Mutex
+	requests uint64
+	errors   map[int]uint64
+	inFlight int64
+	buckets  []uint64
+	sum      time.Duration
+	count    uint64
+}
+
+// statsRegistry keeps per-pattern statistics; only registered patterns get an entry so
+// clients cannot grow it with made-up pattern names
+type statsRegistry struct {
+	bounds   []float64
+	mu       sync.RWMutex
+	patterns map[string]*patternStats
+}
+
+func newStatsRegistry(bounds []float64) *statsRegistry {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &statsRegistry{bounds: bounds, patterns: make(map[string]*patternStats)}
+}
+
+func (r *statsRegistry) pattern(name string) *patternStats {
+	r.mu.RLock()
+	ps, ok := r.patterns[name]
+	r.mu.RUnlock()
+	if ok {
+		return ps
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ps, ok = r.patterns[name]; !ok {
+		ps = &patternStats{errors: make(map[int]uint64), buckets: make([]uint64, len(r.bounds))}
+		r.patterns[name] = ps
+	}
+	return ps
+}
+
+func (ps *patternStats) begin() {
+	ps.mu.Lock()
+	ps.requests++
+	ps.inFlight++
+	ps.mu.Unlock()
+}
+
+// end records a finished request; code is 0 on success
+func (ps *patternStats) end(bounds []float64, elapsed time.Duration, code int) {
+	seconds := elapsed.Seconds()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.inFlight--
+	if code != 0 {
+		ps.errors[code]++
+	}
+	for i, bound := range bounds {
+		if seconds <= bound {
+			ps.buckets[i]++
+		}
+	}
+	ps.sum += elapsed
+	ps.count++
+}
+
+func (r *statsRegistry) snapshot() map[string]PatternStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]PatternStats, len(r.patterns))
+	for name, ps := range r.patterns {
+		ps.mu.Lock()
+		errs := make(map[int]uint64, len(ps.errors))
+		for code, n := range ps.errors {
+			errs[code] = n
+		}
+		out[name] = PatternStats{
+			Requests:     ps.requests,
+			Errors:       errs,
+			InFlight:     ps.inFlight,
+			Buckets:      append([]uint64(nil), ps.buckets...),
+			LatencySum:   ps.sum,
+			LatencyCount: ps.count,
+		}
+		ps.mu.Unlock()
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"errors"
+	"sync"
+)
+
+// StreamHandler answers one request with any number of responses, like a NestJS handler
+// returning an Observable. Each value passed to stream.Send reaches the client as a frame
+// with isDisposed unset; returning nil completes the stream with a final isDisposed frame,
+// returning an error terminates it with an error frame instead.
+//
+// Streams are not bounded by Config.Timeout (use WithTimeout for that) and are never retried.
+// ctx is cancelled when the client cancels the call or the connection goes away.
+type StreamHandler func(ctx *Context, stream *Stream) error
+
+var errStreamClosed = errors.New("stream closed")
+
+// Stream emits the responses of a streaming request
+type Stream struct {
+	c      *connection
+	ctx    *Context
+	mu     sync.Mutex
+	closed bool
+}
+
+func newStream(c *connection, ctx *Context) *Stream {
+	return &Stream{c: c, ctx: ctx}
+}
+
+// Send emits one value. Unlike unary replies it waits for room in the connection's write queue,
+// so a fast producer is slowed down to the pace the connection drains at rather than
+// disconnected. rpc.Client buffers up to StreamBufferSize values per stream and cancels streams
+// whose reader falls further behind. Send fails once the request is cancelled, timed out or
+// finished.
+func (st *Stream) Send(v interface{}) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return errStreamClosed
+	}
+	if err := st.ctx.Err(); err != nil {
+		return err
+	}
+
+	frame, err := st.c.encode(Response{Id: st.ctx.Request.ID, Response: v, Status: "ok"})
+	if err != nil {
+		return err
+	}
+	return st.c.writeWait(st.ctx, frame)
+}
+
+// close stops further sends; it waits for a Send in progress so the final frame is queued last
+func (st *Stream) close() {
+	st.mu.Lock()
+	st.closed = true
+	st.mu.Unlock()
+}
+
+// finish queues the frame ending the stream: a disposal frame, or an error frame if rpcErr is set.
+// Like Send it waits for room, so a burst of values cannot push the last frame out.
+func (st *Stream) finish(rpcErr *Error) {
+	s, c, req := st.c.server, st.c, st.ctx.Request
+	resp := Response{Id: req.ID, Status: "ok", IsDisposed: true}
+	if rpcErr != nil {
+		resp = Response{Id: req.ID, Err: rpcErr, Status: "error", IsDisposed: true}
+	}
+
+	frame, err := c.encode(resp)
+	if err == nil {
+		err = c.writeWait(c.ctx, frame)
+	}
+	if err != nil {
+		s.metrics.mu.Lock()
+		s.metrics.ErrorsTotal++
+		s.metrics.mu.Unlock()
+		s.logger.Warn("Failed to end stream", c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID, "error", err)...)
+		return
+	}
+
+	s.logger.Debug("Stream ended", c.logAttrs("pattern", req.Pattern.Cmd, "request_id", req.ID, "status", resp.Status)...)
+}
+
+// adaptStream runs handler as a ContextHandler so streams share middleware and timeouts
+func adaptStream(handler StreamHandler) ContextHandler {
+	return func(ctx *Context) (interface{}, error) {
+		if ctx.stream == nil {
+			return nil, Internal("Streaming handler called without a stream")
+		}
+		return nil, handler(ctx, ctx.stream)
+	}
+}
@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStreamDeliversValuesInOrder(t *testing.T) {
+	s := newTestServer(t)
+	s.RegisterStreamHandler("count", func(ctx *Context, stream *Stream) error {
+		for i := 0; i < 500; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := client.Stream(ctx, "count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for i := 0; ; i++ {
+		data, err := st.Recv(ctx)
+		if err == io.EOF {
+			if i != 500 {
+				t.Fatalf("stream ended after %d values, want 500", i)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != strconv.Itoa(i) {
+			t.Fatalf("value %d = %s", i, data)
+		}
+	}
+}
+
+func TestClientStreamBufferIsBounded(t *testing.T) {
+	s := newTestServer(t)
+	sendErr := make(chan error, 1)
+	s.RegisterStreamHandler("flood", func(ctx *Context, stream *Stream) error {
+		for i := 0; ; i++ {
+			if err := stream.Send(i); err != nil {
+				sendErr <- err
+				return err
+			}
+		}
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client, err := Dial(context.Background(), &ClientConfig{Addr: addr, StreamBufferSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := client.Stream(ctx, "flood", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// the client cancels the stream instead of buffering the flood
+	select {
+	case <-sendErr:
+	case <-ctx.Done():
+		t.Fatal("server kept streaming into a full client buffer")
+	}
+
+	received := 0
+	for {
+		_, err := st.Recv(ctx)
+		if errors.Is(err, ErrStreamBufferFull) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv = %v, want ErrStreamBufferFull", err)
+		}
+		received++
+	}
+	if received != 8 {
+		t.Fatalf("received %d buffered values, want 8", received)
+	}
+}
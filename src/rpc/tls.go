@@ -0,0 +1,199 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig enables TLS on the server's listeners
+type TLSConfig struct {
+	// CertFile and KeyFile hold the PEM server certificate; they are re-read when they change on disk
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the PEM CAs used to verify client certificates
+	ClientCAFile string
+	// ClientAuth selects client certificate verification, e.g. tls.RequireAndVerifyClientCert for mutual TLS
+	ClientAuth tls.ClientAuthType
+	// ReloadInterval is how often the certificate files are checked for changes, 30s by default
+	ReloadInterval time.Duration
+	// HandshakeTimeout bounds the TLS handshake of a new connection, 10s by default
+	HandshakeTimeout time.Duration
+	// Config is used as the base configuration; it is required when no CertFile is given
+	Config *tls.Config
+}
+
+// PeerIdentity describes a client certificate that passed verification
+type PeerIdentity struct {
+	CommonName   string
+	DNSNames     []string
+	URIs         []string
+	Certificate  *x509.Certificate
+	VerifiedFrom []*x509.Certificate
+}
+
+func (c *TLSConfig) build(logger Logger) (*tls.Config, error) {
+	var base *tls.Config
+	if c.Config != nil {
+		base = c.Config.Clone()
+	} else {
+		base = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		reloader, err := newCertReloader(c.CertFile, c.KeyFile, c.ReloadInterval, logger)
+		if err != nil {
+			return nil, err
+		}
+		base.Certificates = nil
+		base.GetCertificate = reloader.GetCertificate
+	}
+	if len(base.Certificates) == 0 && base.GetCertificate == nil && base.GetConfigForClient == nil {
+		return nil, errors.New("tls: CertFile/KeyFile or a Config with certificates is required")
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", c.ClientCAFile)
+		}
+		base.ClientCAs = pool
+	}
+	if c.ClientAuth != tls.NoClientCert {
+		base.ClientAuth = c.ClientAuth
+	}
+	return base, nil
+}
+
+func (c *TLSConfig) handshakeTimeout() time.Duration {
+	if c == nil || c.HandshakeTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return c.HandshakeTimeout
+}
+
+// certReloader serves the certificate from disk, reloading it when the files change.
+// Checks are lazy: at most one stat per interval, done during a handshake.
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	interval  time.Duration
+	logger    Logger
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration, logger Logger) (*certReloader, error) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("tls: failed to stat certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: failed to stat key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: failed to load key pair: %w", err)
+	}
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	r.lastCheck = time.Now()
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.interval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			// keep serving the old certificate if the new pair is incomplete or invalid
+			if err := r.load(); err != nil {
+				r.logger.Error("Certificate reload failed", "cert_file", r.certFile, "error", err)
+			} else {
+				r.logger.Info("Certificate reloaded", "cert_file", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// handshake completes the TLS handshake of c, if it is a TLS connection, and records the
+// verified client identity. It returns false when the connection must be dropped.
+func (s *Server) handshake(c *connection) bool {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(s.config.TLS.handshakeTimeout())); err != nil {
+		return false
+	}
+	if err := tlsConn.HandshakeContext(c.ctx); err != nil {
+		s.metrics.mu.Lock()
+		s.metrics.TLSHandshakeErrors++
+		s.metrics.mu.Unlock()
+		s.logger.Warn("TLS handshake failed", c.logAttrs("error", err)...)
+		return false
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		return false
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		c.peer = newPeerIdentity(state.VerifiedChains[0])
+	}
+	return true
+}
+
+func newPeerIdentity(chain []*x509.Certificate) *PeerIdentity {
+	leaf := chain[0]
+	peer := &PeerIdentity{
+		CommonName:   leaf.Subject.CommonName,
+		DNSNames:     leaf.DNSNames,
+		Certificate:  leaf,
+		VerifiedFrom: chain[1:],
+	}
+	for _, uri := range leaf.URIs {
+		peer.URIs = append(peer.URIs, uri.String())
+	}
+	return peer
+}
@@ -0,0 +1,298 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate for template, signed by parent or self-signed when parent is nil
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newServerCert(t *testing.T, ca *testCert) *testCert {
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rpc server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func (tc *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(tc.certPEM, tc.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeFile writes data and moves its modification time forward, so a reload sees the change
+// even on file systems with coarse timestamps
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts a server whose certificate is issued by ca, with tlsConfig's files filled in
+func newTLSServer(t *testing.T, ca *testCert, tlsConfig *TLSConfig) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	server := newServerCert(t, ca)
+	tlsConfig.CertFile = filepath.Join(dir, "server.pem")
+	tlsConfig.KeyFile = filepath.Join(dir, "server-key.pem")
+	writeFile(t, tlsConfig.CertFile, server.certPEM, time.Now())
+	writeFile(t, tlsConfig.KeyFile, server.keyPEM, time.Now())
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		tlsConfig.ClientCAFile = filepath.Join(dir, "ca.pem")
+		writeFile(t, tlsConfig.ClientCAFile, ca.certPEM, time.Now())
+	}
+
+	s := NewServer(&Config{Addr: "127.0.0.1:0", Logger: NopLogger, TLS: tlsConfig})
+	s.RegisterContextHandler("whoami", func(ctx *Context) (interface{}, error) {
+		if ctx.Peer == nil {
+			return nil, nil
+		}
+		var chain []string
+		for _, cert := range ctx.Peer.VerifiedFrom {
+			chain = append(chain, cert.Subject.CommonName)
+		}
+		return map[string]interface{}{
+			"commonName": ctx.Peer.CommonName,
+			"dnsNames":   ctx.Peer.DNSNames,
+			"uris":       ctx.Peer.URIs,
+			"chain":      chain,
+		}, nil
+	})
+	addr, _ := serve(t, s)
+	t.Cleanup(func() { shutdown(t, s) })
+	return s, addr
+}
+
+func dialTLS(ctx context.Context, addr string, ca *testCert, certs ...tls.Certificate) (*Client, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return Dial(ctx, &ClientConfig{Addr: addr, TLSConfig: &tls.Config{RootCAs: roots, Certificates: certs}})
+}
+
+func TestTLSHandshake(t *testing.T) {
+	ca := newTestCA(t)
+	_, addr := newTLSServer(t, ca, &TLSConfig{})
+
+	client, err := dialTLS(context.Background(), addr, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	out, err := client.Send(context.Background(), "whoami", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "null" {
+		t.Fatalf("peer without a client certificate = %s, want null", out)
+	}
+
+	// a client that does not trust the server's CA must not get through
+	if _, err := dialTLS(context.Background(), addr, newTestCA(t)); err == nil {
+		t.Fatal("Dial succeeded against an untrusted server certificate")
+	}
+}
+
+func TestMutualTLSRejectsUnauthenticatedClient(t *testing.T) {
+	ca := newTestCA(t)
+	s, addr := newTLSServer(t, ca, &TLSConfig{ClientAuth: tls.RequireAndVerifyClientCert})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	untrusted := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "intruder"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, newTestCA(t))
+
+	for name, certs := range map[string][]tls.Certificate{
+		"no certificate":            nil,
+		"certificate of another CA": {untrusted.tlsCertificate(t)},
+	} {
+		// with TLS 1.3 the client finishes its side first and learns of the rejection on its first read
+		client, err := dialTLS(ctx, addr, ca, certs...)
+		if err == nil {
+			_, err = client.Send(ctx, "whoami", nil)
+			client.Close()
+		}
+		if err == nil {
+			t.Fatalf("%s: request succeeded", name)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.GetMetrics().TLSHandshakeErrors != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("TLSHandshakeErrors = %d, want 2", s.GetMetrics().TLSHandshakeErrors)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMutualTLSPeerIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	_, addr := newTLSServer(t, ca, &TLSConfig{ClientAuth: tls.RequireAndVerifyClientCert})
+
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	clientCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "billing"},
+		DNSNames:    []string{"billing.internal"},
+		URIs:        []*url.URL{spiffe},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	client, err := dialTLS(context.Background(), addr, ca, clientCert.tlsCertificate(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var peer struct {
+		CommonName string   `json:"commonName"`
+		DNSNames   []string `json:"dnsNames"`
+		URIs       []string `json:"uris"`
+		Chain      []string `json:"chain"`
+	}
+	out, err := client.Send(context.Background(), "whoami", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out, &peer); err != nil {
+		t.Fatal(err)
+	}
+	if peer.CommonName != "billing" || len(peer.DNSNames) != 1 || peer.DNSNames[0] != "billing.internal" ||
+		len(peer.URIs) != 1 || peer.URIs[0] != spiffe.String() || len(peer.Chain) != 1 || peer.Chain[0] != "test CA" {
+		t.Fatalf("ctx.Peer = %+v", peer)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	mod := time.Now().Add(-time.Hour)
+	first := newServerCert(t, ca)
+	writeFile(t, certFile, first.certPEM, mod)
+	writeFile(t, keyFile, first.keyPEM, mod)
+
+	r, err := newCertReloader(certFile, keyFile, time.Millisecond, NopLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving := func() *x509.Certificate {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf
+	}
+	if !serving().Equal(first.cert) {
+		t.Fatal("not serving the initial certificate")
+	}
+
+	second := newServerCert(t, ca)
+	mod = mod.Add(time.Minute)
+	writeFile(t, certFile, second.certPEM, mod)
+	writeFile(t, keyFile, second.keyPEM, mod)
+	if !serving().Equal(second.cert) {
+		t.Fatal("changed certificate was not picked up")
+	}
+
+	third := newServerCert(t, ca)
+	for name, pair := range map[string][2][]byte{
+		"mismatched key":   {third.certPEM, second.keyPEM},
+		"garbage key":      {third.certPEM, []byte("not a key")},
+		"half-written key": {third.certPEM, third.keyPEM[:len(third.keyPEM)/2]},
+	} {
+		mod = mod.Add(time.Minute)
+		writeFile(t, certFile, pair[0], mod)
+		writeFile(t, keyFile, pair[1], mod)
+		if !serving().Equal(second.cert) {
+			t.Fatalf("%s: stopped serving the last valid certificate", name)
+		}
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile, 0, NopLogger); err == nil {
+		t.Fatal("newCertReloader accepted a missing certificate")
+	}
+}
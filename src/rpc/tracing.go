@@ -0,0 +1,301 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata keys carrying W3C trace context (https://www.w3.org/TR/trace-context/)
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext identifies a span and what is propagated to other services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Remote is set when the context was extracted from an incoming request
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	// version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	sc.Remote = true
+	return sc, nil
+}
+
+// SpanStatus is the outcome of a span
+type SpanStatus struct {
+	Code    int
+	Message string
+}
+
+// SpanData is the immutable record of a finished span handed to a SpanExporter
+type SpanData struct {
+	Name       string
+	Kind       string
+	Context    SpanContext
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Status     SpanStatus
+}
+
+// SpanExporter receives finished, sampled spans. Export is called on the request's
+// goroutine, so implementations should hand spans off rather than block.
+type SpanExporter interface {
+	Export(span SpanData)
+}
+
+// Span is an in-progress span. All methods are safe on a nil *Span, which is what
+// SpanFromContext returns when tracing is off.
+type Span struct {
+	exporter SpanExporter
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a span as a child of the span in ctx, or a new trace if there is none
+func StartSpan(ctx context.Context, name, kind string, exporter SpanExporter) (context.Context, *Span) {
+	span := newSpan(name, kind, SpanFromContext(ctx).SpanContext(), exporter)
+	return ContextWithSpan(ctx, span), span
+}
+
+func newSpan(name, kind string, parent SpanContext, exporter SpanExporter) *Span {
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	return &Span{
+		exporter: exporter,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Context:    sc,
+			Parent:     parent,
+			Start:      time.Now(),
+			Attributes: make(map[string]interface{}),
+		},
+	}
+}
+
+// nonRecordingSpan propagates sc without recording or exporting anything
+func nonRecordingSpan(sc SpanContext) *Span {
+	return &Span{data: SpanData{Context: sc}, ended: true}
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *Span) SetStatus(code int, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status = SpanStatus{Code: code, Message: message}
+	}
+}
+
+// End finishes the span and exports it if it is sampled; later calls do nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	attrs := make(map[string]interface{}, len(data.Attributes))
+	for k, v := range data.Attributes {
+		attrs[k] = v
+	}
+	data.Attributes = attrs
+	s.mu.Unlock()
+
+	if s.exporter != nil && data.Context.Sampled {
+		s.exporter.Export(data)
+	}
+}
+
+// InMemoryExporter keeps finished spans in memory, for tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported so far, oldest first
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// extractSpanContext reads the W3C trace context from request metadata
+func extractSpanContext(metadata map[string]string) SpanContext {
+	sc, err := ParseTraceparent(metadata[TraceparentKey])
+	if err != nil {
+		return SpanContext{}
+	}
+	sc.TraceState = metadata[TracestateKey]
+	return sc
+}
+
+// injectSpanContext returns the metadata propagating the span in ctx, or nil
+func injectSpanContext(ctx context.Context) map[string]string {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return nil
+	}
+	metadata := map[string]string{TraceparentKey: sc.Traceparent()}
+	if sc.TraceState != "" {
+		metadata[TracestateKey] = sc.TraceState
+	}
+	return metadata
+}
+
+// startServerSpan starts the span for one incoming request or event. The span's context is what
+// handlers see through SpanFromContext. Without an exporter it only carries the incoming trace
+// context, so calls made from the handler stay in the trace; it is nil if there is none.
+func (s *Server) startServerSpan(c *connection, req *Request, kind string) *Span {
+	remote := extractSpanContext(req.Metadata)
+	if s.config.SpanExporter == nil {
+		if !remote.IsValid() {
+			return nil
+		}
+		return nonRecordingSpan(remote)
+	}
+	span := newSpan(req.Pattern.Cmd, kind, remote, s.config.SpanExporter)
+	span.SetAttribute("rpc.pattern", req.Pattern.Cmd)
+	span.SetAttribute("rpc.conn_id", c.id)
+	span.SetAttribute("net.peer.addr", c.conn.RemoteAddr().String())
+	if req.ID != "" {
+		span.SetAttribute("rpc.request_id", req.ID)
+	}
+	return span
+}
+
+// endServerSpan records the status code (0 on success) and finishes span
+func endServerSpan(span *Span, code int, message string) {
+	span.SetAttribute("rpc.status_code", code)
+	if code != 0 {
+		span.SetStatus(code, message)
+	}
+	span.End()
+}
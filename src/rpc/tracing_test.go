@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTraceContextPropagatesWithoutExporter(t *testing.T) {
+	s := newTestServer(t)
+	seen := make(chan SpanContext, 1)
+	propagated := make(chan map[string]string, 1)
+	s.RegisterContextHandler("traced", func(ctx *Context) (interface{}, error) {
+		seen <- SpanFromContext(ctx).SpanContext()
+		propagated <- injectSpanContext(ctx)
+		return nil, nil
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	ctx, caller := StartSpan(context.Background(), "caller", "client", nil)
+	if _, err := client.Send(ctx, "traced", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := <-seen
+	if sc.TraceID != caller.SpanContext().TraceID || !sc.Remote {
+		t.Fatalf("handler span context = %+v, want the caller's trace", sc)
+	}
+	if md := <-propagated; md[TraceparentKey] != caller.SpanContext().Traceparent() {
+		t.Fatalf("outgoing traceparent = %q, want %q", md[TraceparentKey], caller.SpanContext().Traceparent())
+	}
+}
+
+func TestEventHandlersSeeTheTrace(t *testing.T) {
+	s := newTestServer(t)
+	seen := make(chan SpanContext, 1)
+	s.RegisterEventContextHandler("traced.event", func(ctx *Context) error {
+		seen <- SpanFromContext(ctx).SpanContext()
+		return nil
+	})
+	addr, _ := serve(t, s)
+	defer shutdown(t, s)
+	client := dial(t, addr)
+
+	ctx, caller := StartSpan(context.Background(), "caller", "client", nil)
+	if err := client.Emit(ctx, "traced.event", nil); err != nil {
+		t.Fatal(err)
+	}
+	if sc := <-seen; sc.TraceID != caller.SpanContext().TraceID {
+		t.Fatalf("event handler span context = %+v, want the caller's trace", sc)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		sc, err := ParseTraceparent(tt.value)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseTraceparent(%q) = %v, want valid %t", tt.value, err, tt.valid)
+			continue
+		}
+		if tt.valid && tt.value[:2] == "00" && sc.Traceparent() != tt.value {
+			t.Errorf("Traceparent() = %q, want %q", sc.Traceparent(), tt.value)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package rpc
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 )
 
 type Pattern struct {
@@ -10,9 +11,41 @@ type Pattern struct {
 }
 
 type Request struct {
-	ID      string          `json:"id"`
-	Pattern Pattern         `json:"pattern"`
-	Data    json.RawMessage `json:"data"`
+	ID      string  `json:"id"`
+	Pattern Pattern `json:"pattern"`
+	// Data is the request data as JSON. With a binary codec it is nil and the data stays in the
+	// wire encoding until Context.Bind decodes it; data set here takes precedence.
+	Data json.RawMessage `json:"data"`
+	// Metadata carries out-of-band values such as the W3C traceparent and tracestate
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Cancel marks a frame asking the server to cancel the in-flight request with this ID
+	Cancel bool `json:"cancel,omitempty"`
+
+	codec Codec
+	raw   []byte
+}
+
+// bind decodes the request data into v, from Data or else from the wire encoding
+func (r *Request) bind(v interface{}) error {
+	switch {
+	case len(r.Data) > 0:
+		return json.Unmarshal(r.Data, v)
+	case len(r.raw) > 0:
+		return r.codec.Unmarshal(r.raw, v)
+	}
+	return nil
+}
+
+// jsonData returns the request data as JSON, converting it from the wire encoding if needed
+func (r *Request) jsonData() (json.RawMessage, error) {
+	if len(r.Data) > 0 || len(r.raw) == 0 {
+		return r.Data, nil
+	}
+	var data json.RawMessage
+	if err := r.codec.Unmarshal(r.raw, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 type Response struct {
@@ -20,31 +53,74 @@ type Response struct {
 	Response   interface{} `json:"response,omitempty"`
 	IsDisposed bool        `json:"isDisposed,omitempty"`
 	Status     string      `json:"status,omitempty"`
-	Err        string      `json:"err,omitempty"`
+	Err        interface{} `json:"err,omitempty"`
 }
 
-func parseRequest(msgBytes []byte) (*Request, error) {
-
-	var raw struct {
-		ID      string          `json:"id"`
-		Pattern json.RawMessage `json:"pattern"`
-		Data    json.RawMessage `json:"data"`
+// extractRequestID recovers the id of a frame that failed full parsing so the error
+// reply can still be correlated. It returns "" when the frame is not an object or
+// carries no usable id.
+func extractRequestID(codec Codec, payload []byte) string {
+	env, err := decodeEnvelope(codec, payload)
+	if err != nil || len(env.ID) == 0 {
+		return ""
+	}
+	var id string
+	if err := codec.Unmarshal(env.ID, &id); err == nil {
+		return id
 	}
-	if err := json.Unmarshal(msgBytes, &raw); err != nil {
+	var num json.Number
+	if err := codec.Unmarshal(env.ID, &num); err == nil {
+		return num.String()
+	}
+	var i int64
+	if err := codec.Unmarshal(env.ID, &i); err == nil {
+		return strconv.FormatInt(i, 10)
+	}
+	var u uint64
+	if err := codec.Unmarshal(env.ID, &u); err == nil {
+		return strconv.FormatUint(u, 10)
+	}
+	return ""
+}
+
+// parseRequest decodes a request envelope with the connection's codec. Data is kept as JSON
+// for the JSON codec and left in the wire encoding otherwise.
+func parseRequest(codec Codec, payload []byte) (*Request, error) {
+	env, err := decodeEnvelope(codec, payload)
+	if err != nil {
 		return nil, err
 	}
 
-	req := &Request{
-		ID:   raw.ID,
-		Data: raw.Data,
+	req := &Request{Cancel: env.Cancel}
+	if len(env.ID) > 0 {
+		if err := codec.Unmarshal(env.ID, &req.ID); err != nil {
+			return nil, err
+		}
+	}
+	if codec == JSON {
+		req.Data = env.Data
+	} else {
+		req.codec, req.raw = codec, env.Data
+	}
+	// metadata that is not a string map is ignored rather than failing the request
+	if len(env.Metadata) > 0 {
+		var metadata map[string]string
+		if err := codec.Unmarshal(env.Metadata, &metadata); err == nil {
+			req.Metadata = metadata
+		}
+	}
+	// cancel frames carry no pattern
+	if req.Cancel {
+		return req, nil
 	}
 
-	if err := json.Unmarshal(raw.Pattern, &req.Pattern); err == nil {
+	if err := codec.Unmarshal(env.Pattern, &req.Pattern); err == nil {
 		return req, nil
 	}
 
+	// the pattern may also be a string holding its JSON
 	var patternStr string
-	if err := json.Unmarshal(raw.Pattern, &patternStr); err == nil {
+	if err := codec.Unmarshal(env.Pattern, &patternStr); err == nil {
 		if err := json.Unmarshal([]byte(patternStr), &req.Pattern); err == nil {
 			return req, nil
 		}
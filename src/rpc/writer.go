@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	errConnClosed   = errors.New("connection closed")
+	errSlowConsumer = errors.New("write queue full (slow consumer)")
+)
+
+// write queues one complete frame for the connection's writer goroutine. It never blocks:
+// a peer that lets its queue fill up is treated as a slow consumer and disconnected.
+func (c *connection) write(frame []byte) error {
+	select {
+	case <-c.writerDone:
+		return errConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- frame:
+		return nil
+	default:
+	}
+
+	s := c.server
+	s.metrics.mu.Lock()
+	s.metrics.WritesDropped++
+	s.metrics.mu.Unlock()
+	c.slowOnce.Do(func() {
+		s.metrics.mu.Lock()
+		s.metrics.SlowConsumers++
+		s.metrics.mu.Unlock()
+		s.logger.Warn("Slow consumer, disconnecting", c.logAttrs("queue_size", cap(c.out))...)
+		c.conn.Close()
+	})
+	return errSlowConsumer
+}
+
+// writeWait queues a frame like write, but waits for room in the queue until ctx is done
+func (c *connection) writeWait(ctx context.Context, frame []byte) error {
+	select {
+	case <-c.writerDone:
+		return errConnClosed
+	default:
+	}
+
+	select {
+	case c.out <- frame:
+		return nil
+	case <-c.writerDone:
+		return errConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeLoop is the only goroutine writing to the socket, so frames never interleave
+func (c *connection) writeLoop() {
+	defer close(c.writerDone)
+	for {
+		select {
+		case frame := <-c.out:
+			if !c.flush(frame) {
+				return
+			}
+		case <-c.quit:
+			// drain whatever was queued before the writer was stopped
+			for {
+				select {
+				case frame := <-c.out:
+					if !c.flush(frame) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *connection) flush(frame []byte) bool {
+	s := c.server
+	if err := c.conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout)); err != nil {
+		c.server.logger.Warn("Failed to set write deadline", c.logAttrs("error", err)...)
+	}
+
+	if _, err := c.conn.Write(frame); err != nil {
+		s.metrics.mu.Lock()
+		s.metrics.WritesDropped++
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			s.metrics.WritesBlocked++
+		}
+		s.metrics.mu.Unlock()
+		if !isClosedError(err) {
+			c.server.logger.Warn("Write failed, closing connection", c.logAttrs("error", err)...)
+		}
+		c.conn.Close()
+		return false
+	}
+	return true
+}
+
+// closeWriter stops the writer after it has flushed the queue and waits for it to exit
+func (c *connection) closeWriter() {
+	c.quitOnce.Do(func() { close(c.quit) })
+	<-c.writerDone
+}